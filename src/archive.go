@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+type Archive struct {
+	Accounts   []*AccountArchive        `json:"accounts"`
+	Statistics []*SubscriptionStatistic `json:"statistics"`
+}
+
+type AccountArchive struct {
+	Account       *Account                          `json:"account"`
+	Subscriptions map[string]*Subscription          `json:"subscriptions"`
+	Caches        map[string]map[string]interface{} `json:"caches"`
+	Outbox        []*OutboxEntry                    `json:"outbox"`
+}
+
+func RunArchiveCommands() (bool, error) {
+	switch {
+	case len(args.Export) > 0:
+		archive, err := Export(SharedStore())
+		if err != nil {
+			return true, err
+		}
+		return true, WriteArchive(args.Export, archive)
+
+	case len(args.Import) > 0:
+		archive, err := ReadArchive(args.Import)
+		if err != nil {
+			return true, err
+		}
+		return true, Import(SharedStore(), archive)
+
+	case len(args.Migrate) > 0:
+		if args.Migrate == storage {
+			return true, fmt.Errorf("source and destination storage are both %s", storage)
+		}
+		archive, err := Export(SharedStore())
+		if err != nil {
+			return true, err
+		}
+		return true, Import(NewStore(args.Migrate), archive)
+
+	default:
+		return false, nil
+	}
+}
+
+func Export(store Store) (*Archive, error) {
+	archive := &Archive{
+		Accounts: make([]*AccountArchive, 0),
+	}
+
+	accounts, err := store.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, account := range accounts {
+		subscriptions, err := store.GetSubscriptions(account)
+		if err != nil {
+			return nil, err
+		}
+
+		caches := make(map[string]map[string]interface{})
+		for id, subscription := range subscriptions {
+			cache, err := store.GetFeedCache(account, subscription)
+			if err != nil {
+				return nil, err
+			}
+			caches[id] = cache
+		}
+
+		outbox, err := store.GetOutbox(account)
+		if err != nil {
+			return nil, err
+		}
+
+		archive.Accounts = append(archive.Accounts, &AccountArchive{
+			Account:       account,
+			Subscriptions: subscriptions,
+			Caches:        caches,
+			Outbox:        outbox,
+		})
+	}
+
+	archive.Statistics, err = store.GetStatistics()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Exported %d accounts and %d statistics", len(archive.Accounts), len(archive.Statistics))
+
+	return archive, nil
+}
+
+func Import(store Store, archive *Archive) error {
+	for _, entry := range archive.Accounts {
+		err := store.SaveAccount(entry.Account)
+		if err != nil {
+			return err
+		}
+
+		existing, err := store.GetSubscriptions(entry.Account)
+		if err != nil {
+			return err
+		}
+
+		for id, subscription := range entry.Subscriptions {
+			if existing[id] == nil {
+				err = store.AddSubscription(entry.Account, subscription)
+				if err != nil {
+					return err
+				}
+			}
+
+			if cache := entry.Caches[id]; cache != nil {
+				err = store.SetFeedCache(entry.Account, subscription, cache)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if len(entry.Outbox) > 0 {
+			err = store.AddOutboxEntries(entry.Account, entry.Outbox)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Adding subscriptions bumps the counters, overwrite them with the archived values.
+	for _, statistic := range archive.Statistics {
+		err := store.SetStatistic(statistic)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Imported %d accounts and %d statistics", len(archive.Accounts), len(archive.Statistics))
+
+	return Verify(store, archive)
+}
+
+func Verify(store Store, archive *Archive) error {
+	mismatches := 0
+
+	for _, entry := range archive.Accounts {
+		subscriptions, err := store.GetSubscriptions(entry.Account)
+		if err != nil {
+			return err
+		}
+
+		expected, actual := 0, 0
+		for id, subscription := range entry.Subscriptions {
+			expected += len(entry.Caches[id])
+			if subscriptions[id] == nil {
+				continue
+			}
+
+			cache, err := store.GetFeedCache(entry.Account, subscription)
+			if err != nil {
+				return err
+			}
+			actual += len(cache)
+		}
+
+		if len(subscriptions) != len(entry.Subscriptions) || actual != expected {
+			log.Printf("Account %d mismatch: %d/%d subscriptions, %d/%d cached items", entry.Account.Id, len(subscriptions), len(entry.Subscriptions), actual, expected)
+			mismatches++
+		}
+	}
+
+	if mismatches > 0 {
+		return fmt.Errorf("verification failed for %d of %d accounts", mismatches, len(archive.Accounts))
+	}
+
+	log.Printf("Verified %d accounts", len(archive.Accounts))
+
+	return nil
+}
+
+func ReadArchive(path string) (*Archive, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var archive Archive
+	err = json.Unmarshal(data, &archive)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archive, nil
+}
+
+func WriteArchive(path string, archive *Archive) error {
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
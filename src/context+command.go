@@ -1,83 +1,665 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 )
 
 // Handlers
 
-func (context *Context) HandleListCommand() string {
-	subscriptions := context.GetSubscriptions()
-	if len(subscriptions) == 0 {
-		return `Your list is empty.`
-	}
+func (context *Context) HandleListCommand(args string) (string, *tgbotapi.InlineKeyboardMarkup) {
+	usage := "Usage:\n" +
+		"/list [-s added|title|updated|activity] [query]\n\n" +
+		"The query searches the titles, links and tags, -s orders by the date added, the title, the last item or the items of the last 30 days."
+
+	view := ListView{Sort: SortAdded}
 
-	var message string
-	for idx, subscription := range subscriptions {
-		message += fmt.Sprintf("%d. [%s](%s) \n", idx+1, subscription.Title, subscription.Link)
+	fields := strings.Fields(args)
+	if len(fields) > 0 && fields[0] == "-s" {
+		if len(fields) < 2 || !containsString(listSorts, fields[1]) {
+			return format(usage), nil
+		}
+		view.Sort = fields[1]
+		fields = fields[2:]
 	}
-	return message
+	view.Query = strings.Join(fields, " ")
+
+	context.SetListView(view)
+
+	return context.ListPage(0)
 }
 
-func (context *Context) HandleSubscribeCommand(args string) string {
+func (context *Context) HandleSubscribeCommand(args string) (string, *tgbotapi.InlineKeyboardMarkup) {
 	if len(args) == 0 || !isValidURL(args) {
-		return `Unable to parse the url.`
+		return format(`Unable to parse the url.`), nil
 	}
 
-	if channel, items, err := FetchChannel(args); err != nil {
-		return `Fetch error.`
-	} else if subscription, err := context.Subscribe(channel); err != nil {
-		return `Subscribe failed.`
-	} else if err := context.SetItemsPushed(subscription, items); err != nil {
-		return `Subscribe failed.`
-	} else if err := context.StartObserving(subscription); err != nil {
-		return `Subscribe failed.`
-	} else {
-		if len(items) == 0 {
-			return fmt.Sprintf(`[%s](%s) subscribed.`, subscription.Title, subscription.Link)
-		} else {
-			return fmt.Sprintf(`[%s](%s) subscribed. Here is the latest feed from the channel.
+	// Full chats are told before the feed is fetched.
+	err := context.CheckLimit()
+
+	var channel *Channel
+	var items []*Item
+	if err == nil {
+		channel, items, err = FetchChannel(args)
+	}
+
+	// A web page with several feeds, the user picks one.
+	var choice *FeedChoiceError
+	if errors.As(err, &choice) {
+		return context.ChoicePage(choice)
+	}
+
+	var subscription *Subscription
+	if err == nil {
+		subscription, err = context.SubscribeChannel(channel, items, nil)
+	}
+	if err != nil {
+		log.Printf("Chat %d failed to subscribe to %s: %v", context.id, args, err)
+		return subscribeError(err), nil
+	}
+
+	if len(items) == 0 {
+		return format(`%s subscribed.`, link(subscription.Title, subscription.Link)), nil
+	}
+
+	return format(`%s subscribed. Here is the latest feed from the channel.
 			
-[%s](%s)`, subscription.Title, subscription.Link, items[0].title, items[0].link)
+%s`, link(subscription.Title, subscription.Link), link(items[0].title, items[0].link)), nil
+}
+
+func (context *Context) HandleUnsubscribeCommand(args string) string {
+	subscription := context.FindSubscription(args)
+	if subscription == nil {
+		return format(`Invalid index, send /list to see your subscriptions.`)
+	}
+
+	if err := context.Unsubscribe(subscription); err != nil {
+		return format(`Unsubscribe failed.`)
+	} else if err := context.StopObserving(subscription); err != nil {
+		return format(`Unsubscribe failed.`)
+	} else {
+		return format(`%s unsubscribed.`, link(subscription.Title, subscription.Link))
+	}
+}
+
+func (context *Context) HandleFilterCommand(args string) string {
+	usage := "Usage:\n" +
+		"/filter <index> - list the filters\n" +
+		"/filter <index> include|exclude [-r] [-c] [-f title,description,categories,author] <pattern>\n" +
+		"/filter <index> remove <number>\n\n" +
+		"-r treats the pattern as a regular expression, -c makes it case sensitive, -f limits the fields matched."
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return format(usage)
+	}
+
+	subscription := context.FindSubscription(fields[0])
+	if subscription == nil {
+		return format(`Invalid index, send /list to see your subscriptions.`)
+	}
+
+	filters := context.GetFilters(subscription)
+
+	if len(fields) == 1 {
+		if len(filters) == 0 {
+			return format(`%s has no filters.`, link(subscription.Title, subscription.Link))
+		}
+
+		message := format("Filters of %s:\n", link(subscription.Title, subscription.Link))
+		for idx, filter := range filters {
+			message += format("%d. %s\n", idx+1, filter)
+		}
+		return message
+	}
+
+	switch fields[1] {
+	case "include", "exclude":
+		var regex, caseSensitive bool
+		var on []string
+
+		rest := fields[2:]
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+			switch rest[0] {
+			case "-r":
+				regex = true
+			case "-c":
+				caseSensitive = true
+			case "-f":
+				if len(rest) < 2 {
+					return format(usage)
+				}
+				on = strings.Split(rest[1], ",")
+				rest = rest[1:]
+			default:
+				return format(usage)
+			}
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return format(usage)
+		}
+
+		filter, err := NewFilter(fields[1] == "exclude", strings.Join(rest, " "), regex, caseSensitive, on)
+		if err != nil {
+			return format("Invalid filter: %s", code(err.Error()))
+		}
+		filters = append(filters, filter)
+
+	case "remove", "delete":
+		if len(fields) < 3 {
+			return format(usage)
+		}
+		index, err := strconv.Atoi(fields[2])
+		if err != nil || index <= 0 || index > len(filters) {
+			return format(`Invalid filter number.`)
+		}
+		filters = append(filters[:index-1], filters[index:]...)
+
+	default:
+		return format(usage)
+	}
+
+	if err := context.SetFilters(subscription, filters); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return context.HandleFilterCommand(fields[0])
+}
+
+func (context *Context) HandleDigestCommand(args string) string {
+	usage := "Usage:\n" +
+		"/digest on|off - batch the new items of this chat\n" +
+		"/digest at <HH:MM>... - send the digest daily at these times\n" +
+		"/digest hourly, /digest every <duration> - send the digest periodically\n" +
+		"/digest limit <number> - cap the items per digest\n" +
+		"/digest now - send the pending items now\n" +
+		"/digest <index> on|off|default - override the chat for a subscription"
+
+	fields := strings.Fields(args)
+	digest, pending := context.GetDigest()
+
+	if len(fields) == 0 {
+		state := "off"
+		if digest.Enabled {
+			state = "on"
+		}
+		return format("Digest is %s: %s (%s).\n%d items pending.\n\n%s", state, digest, context.Location(), pending, usage)
+	}
+
+	switch fields[0] {
+	case "on":
+		digest.Enabled = true
+	case "off":
+		digest.Enabled = false
+	case "at":
+		if len(fields) < 2 {
+			return format(usage)
+		}
+		for _, clock := range fields[1:] {
+			if _, _, err := parseClock(clock); err != nil {
+				return format("Invalid time %s, use HH:MM.", code(clock))
+			}
+		}
+		digest.Enabled = true
+		digest.Times = fields[1:]
+		digest.Interval = 0
+	case "hourly":
+		digest.Enabled = true
+		digest.Interval = 60
+	case "every":
+		if len(fields) != 2 {
+			return format(usage)
+		}
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil || interval < time.Minute || interval > 24*time.Hour {
+			return format(`Invalid interval, use a duration between 1m and 24h such as 30m or 3h.`)
+		}
+		digest.Enabled = true
+		digest.Interval = int(interval / time.Minute)
+	case "limit":
+		if len(fields) != 2 {
+			return format(usage)
+		}
+		limit, err := strconv.Atoi(fields[1])
+		if err != nil || limit <= 0 {
+			return format(`Invalid limit.`)
+		}
+		digest.Limit = limit
+	case "now":
+		if err := context.Compose(); err != nil {
+			return format(`Oops, something wrong happened.`)
+		}
+		return format(`Pending items sent.`)
+	default:
+		subscription := context.FindSubscription(fields[0])
+		if subscription == nil || len(fields) != 2 {
+			return format(usage)
+		}
+
+		var delivery string
+		switch fields[1] {
+		case "on":
+			delivery = DeliveryDigest
+		case "off":
+			delivery = DeliveryInstant
+		case "default":
+			delivery = DeliveryDefault
+		default:
+			return format(usage)
+		}
+
+		if err := context.SetDelivery(subscription, delivery); err != nil {
+			return format(`Oops, something wrong happened.`)
+		}
+		return format(`Delivery of %s set to %s.`, link(subscription.Title, subscription.Link), fields[1])
+	}
+
+	if err := context.SetDigest(digest); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	// Items already batched are sent rather than held back.
+	if !digest.Enabled {
+		if err := context.Compose(); err != nil {
+			return format(`Oops, something wrong happened.`)
 		}
 	}
+
+	return context.HandleDigestCommand("")
 }
 
-func (context *Context) HandleUnsubscribeCommand(args string) string {
-	subscriptions := context.GetSubscriptions()
+func (context *Context) HandleTimezoneCommand(args string) string {
+	if len(args) == 0 {
+		return format("The timezone of this chat is %s.\n\nUsage: /timezone <name>, such as Europe/Berlin.", context.Location())
+	}
 
-	index, err := strconv.Atoi(args)
-	if err != nil || index <= 0 || index > len(subscriptions) {
-		return fmt.Sprintf(`Invalid index.
-			
-%s`, context.HandleListCommand())
+	if _, err := time.LoadLocation(args); err != nil {
+		return format("Unknown timezone %s, use a name such as Europe/Berlin.", code(args))
 	}
 
-	index -= 1
+	if err := context.SetTimezone(args); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
 
-	subscription := subscriptions[index]
+	return context.HandleTimezoneCommand("")
+}
 
-	if err := context.Unsubscribe(subscription); err != nil {
-		return `Unsubscribe failed.`
-	} else if err := context.StopObserving(subscription); err != nil {
-		return `Unsubscribe failed.`
+// HandlePauseCommand pauses or resumes the chat, or the subscription given by its index.
+func (context *Context) HandlePauseCommand(args string, paused bool) string {
+	var subscription *Subscription
+	if len(args) > 0 {
+		subscription = context.FindSubscription(args)
+		if subscription == nil {
+			return format(`Invalid index, send /list to see your subscriptions.`)
+		}
+	}
+
+	if err := context.SetPaused(subscription, paused); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	target := Markup(format("this chat"))
+	if subscription != nil {
+		target = link(subscription.Title, subscription.Link)
+	}
+	if paused {
+		return format("Paused %s, new items are skipped until /resume.", target)
+	}
+	return format("Resumed %s.", target)
+}
+
+func (context *Context) HandleMuteCommand(args string) string {
+	usage := `Usage: /mute [index] <duration>|off, such as /mute 2h or /mute 3 1d`
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		return format(usage)
+	}
+
+	var subscription *Subscription
+	if len(fields) == 2 {
+		subscription = context.FindSubscription(fields[0])
+		if subscription == nil {
+			return format(`Invalid index, send /list to see your subscriptions.`)
+		}
+	}
+
+	target := Markup(format("this chat"))
+	if subscription != nil {
+		target = link(subscription.Title, subscription.Link)
+	}
+
+	if fields[len(fields)-1] == "off" {
+		if err := context.SetMuted(subscription, 0); err != nil {
+			return format(`Oops, something wrong happened.`)
+		}
+		return format("Unmuted %s.", target)
+	}
+
+	duration, err := parseDuration(fields[len(fields)-1])
+	if err != nil || duration <= 0 {
+		return format(usage)
+	}
+
+	until := time.Now().Add(duration)
+	if err := context.SetMuted(subscription, until.Unix()); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return format("Muted %s until %s, new items are skipped until then.", target, until.In(context.Location()).Format("2006-01-02 15:04 MST"))
+}
+
+func (context *Context) HandleQuietCommand(args string) string {
+	usage := "Usage:\n" +
+		"/quiet <HH:MM> <HH:MM> [hold|silent] - hold messages until the quiet hours end, or send them without notification\n" +
+		"/quiet off"
+
+	fields := strings.Fields(args)
+	quiet, paused, muted := context.GetQuiet()
+
+	if len(fields) == 0 {
+		message := fmt.Sprintf("Quiet hours: %s (%s).", quiet, context.Location())
+		if paused {
+			message += "\nThis chat is paused."
+		}
+		if muted > time.Now().Unix() {
+			message += fmt.Sprintf("\nThis chat is muted until %s.", time.Unix(muted, 0).In(context.Location()).Format("2006-01-02 15:04 MST"))
+		}
+		return format("%s\n\n%s", message, usage)
+	}
+
+	if fields[0] == "off" {
+		quiet = Quiet{}
 	} else {
-		return fmt.Sprintf(`[%s](%s) unsubscribed.`, subscription.Title, subscription.Link)
+		if len(fields) < 2 || len(fields) > 3 {
+			return format(usage)
+		}
+		for _, clock := range fields[:2] {
+			if _, _, err := parseClock(clock); err != nil {
+				return format("Invalid time %s, use HH:MM.", code(clock))
+			}
+		}
+
+		quiet = Quiet{
+			Start: fields[0],
+			End:   fields[1],
+		}
+		if len(fields) == 3 {
+			switch fields[2] {
+			case "hold":
+				quiet.Hold = true
+			case "silent":
+				quiet.Hold = false
+			default:
+				return format(usage)
+			}
+		}
+	}
+
+	if err := context.SetQuiet(quiet); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return context.HandleQuietCommand("")
+}
+
+func (context *Context) HandleFailedCommand(args string) string {
+	usage := "Usage:\n" +
+		"/failed - list the messages Telegram refused\n" +
+		"/failed retry - send them again\n" +
+		"/failed clear - forget them"
+
+	switch strings.TrimSpace(args) {
+	case "":
+	case "retry":
+		if err := context.RetryFailed(); err != nil {
+			return format(`Oops, something wrong happened.`)
+		}
+		return format(`The refused messages are sent again.`)
+	case "clear":
+		if err := context.ClearFailed(); err != nil {
+			return format(`Oops, something wrong happened.`)
+		}
+		return format(`The refused messages are forgotten.`)
+	default:
+		return format(usage)
+	}
+
+	failed := context.GetFailed()
+	if len(failed) == 0 {
+		return format("Telegram refused no message.\n\n%s", usage)
+	}
+
+	message := format("Telegram refused the last %d messages, up to %d are kept:\n", len(failed), maxFailed)
+	for index, entry := range failed {
+		text := []rune(FormatterFor(entry.ParseMode).Plain(entry.Message))
+		if len(text) > 100 {
+			text = append(text[:100], '…')
+		}
+		message += format("\n%d. %s", index+1, strings.Join(strings.Fields(string(text)), " "))
+	}
+
+	return message + format("\n\n%s", usage)
+}
+
+func (context *Context) HandleTemplateCommand(args string) string {
+	usage := "Usage:\n" +
+		"/template [index] - show the template of the chat or a subscription\n" +
+		"/template [index] " + strings.Join(templatePresetNames, "|") + " - use a built-in template\n" +
+		"/template [index] <template> - use your own, such as {{bold .Title}} {{.Link}}\n" +
+		"/template [index] preview - render the latest item\n" +
+		"/template [index] default - go back to the default\n\n" +
+		"Fields: .Title .Link .Summary .Description .Content .Authors .Categories .Published .Updated .Image .Comments .Enclosures .Source.Title .Source.Link"
+
+	first, rest := splitFirst(args)
+
+	var subscription *Subscription
+	if len(first) > 0 {
+		if subscription = context.FindSubscription(first); subscription != nil {
+			first, rest = splitFirst(rest)
+		}
+	}
+
+	target := Markup(format("this chat"))
+	if subscription != nil {
+		target = link(subscription.Title, subscription.Link)
 	}
+
+	text := context.GetTemplate(subscription)
+
+	switch {
+	case len(first) == 0:
+		current := text
+		if len(current) == 0 {
+			current = "default"
+		}
+		return format("The template of %s is %s.\n\n%s", target, code(current), usage)
+
+	case first == "preview":
+		return context.previewTemplate(subscription, text)
+
+	case first == "default":
+		text = ""
+
+	default:
+		text = strings.TrimSpace(first + " " + rest)
+		if _, err := ValidateTemplate(text); err != nil {
+			return format("Invalid template: %s", code(err.Error()))
+		}
+	}
+
+	if err := context.SetTemplate(subscription, text); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return context.previewTemplate(subscription, text)
+}
+
+// previewTemplate renders the latest item of the subscription, or a sample item.
+func (context *Context) previewTemplate(subscription *Subscription, text string) string {
+	if len(text) == 0 && subscription != nil {
+		text = context.GetTemplate(nil)
+	}
+	if len(text) == 0 {
+		text = defaultTemplate
+	}
+
+	if subscription == nil {
+		message, err := ValidateTemplate(text)
+		if err != nil {
+			return format("Invalid template: %s", code(err.Error()))
+		}
+		return format("Preview:\n\n%s", Markup(message))
+	}
+
+	_, items, err := FetchChannel(subscription.Link)
+	if err != nil || len(items) == 0 {
+		message, err := ValidateTemplate(text)
+		if err != nil {
+			return format("Invalid template: %s", code(err.Error()))
+		}
+		return format("Preview with a sample item:\n\n%s", Markup(message))
+	}
+
+	latest := make(map[string]*Item)
+	for _, item := range items {
+		latest[item.id] = item
+	}
+	sorted := SortItems(latest)
+
+	message, err := RenderItem(text, subscription, sorted[len(sorted)-1], context.Location())
+	if err != nil {
+		return format("Invalid template: %s", code(err.Error()))
+	}
+
+	return format("Preview:\n\n%s", Markup(message))
+}
+
+func (context *Context) HandlePermissionCommand(args string) string {
+	fields := strings.Fields(args)
+
+	permission, allowlist := context.GetPermission()
+	if len(fields) == 0 {
+		switch permission {
+		case PermissionEveryone:
+			return format(`Everyone can manage subscriptions in this chat.`)
+		case PermissionAllowlist:
+			return format(`Administrators and these members can manage subscriptions in this chat: %v`, allowlist)
+		default:
+			return format(`Only administrators can manage subscriptions in this chat.`)
+		}
+	}
+
+	ids := make([]int64, 0)
+	for _, field := range fields[1:] {
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return format(`Invalid user id %s.`, field)
+		}
+		ids = append(ids, id)
+	}
+
+	switch fields[0] {
+	case "everyone":
+		permission = PermissionEveryone
+	case "admins":
+		permission = PermissionAdmins
+	case "allow":
+		if len(ids) == 0 {
+			return format(`Usage: /permission allow <user id>..., or reply to a message of the member.`)
+		}
+		permission = PermissionAllowlist
+		for _, id := range ids {
+			if !containsID(allowlist, id) {
+				allowlist = append(allowlist, id)
+			}
+		}
+	case "disallow":
+		if len(ids) == 0 {
+			return format(`Usage: /permission disallow <user id>..., or reply to a message of the member.`)
+		}
+		remaining := make([]int64, 0)
+		for _, id := range allowlist {
+			if !containsID(ids, id) {
+				remaining = append(remaining, id)
+			}
+		}
+		allowlist = remaining
+	default:
+		return format(`Usage: /permission [everyone|admins|allow <user id>...|disallow <user id>...]`)
+	}
+
+	if err := context.SetPermission(permission, allowlist); err != nil {
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return context.HandlePermissionCommand("")
 }
 
 func (context *Context) HandleHotCommand(args string) string {
-	if statistics, err := SharedFirebase().GetTopSubscriptions(5); err != nil {
-		return `Oops, something wrong happened.`
+	if statistics, err := SharedStore().GetTopSubscriptions(5); err != nil {
+		return format(`Oops, something wrong happened.`)
 	} else if len(statistics) == 0 {
-		return "Not enough data."
+		return format("Not enough data.")
 	} else {
 		var message string
 		for idx, statistic := range statistics {
-			message += fmt.Sprintf("%d. [%s](%s) (👥 %d)\n", idx+1, statistic.Subscription.Title, statistic.Subscription.Link, statistic.Count)
+			message += format("%d. %s (👥 %d)\n", idx+1, link(statistic.Subscription.Title, statistic.Subscription.Link), statistic.Count)
 		}
 		return message
 	}
 
 }
+
+// subscribeError tells the user why subscribing failed and what to do about it.
+func subscribeError(err error) string {
+	var invalid *InvalidURLError
+	var duplicate *DuplicateError
+	var limit *LimitError
+	var network *NetworkError
+	var status *StatusError
+	var notFeed *NotFeedError
+	var tooLarge *TooLargeError
+	var parse *ParseError
+	var storage *StorageError
+
+	switch {
+	case errors.As(err, &invalid):
+		return format(`%s is not a valid address.`, invalid.URL)
+	case errors.As(err, &duplicate):
+		return format(`You are already subscribed to %s.`, link(duplicate.Subscription.Title, duplicate.Subscription.Link))
+	case errors.As(err, &limit):
+		return format(`This chat reached its limit of %d subscriptions, send /delete to remove some first.`, limit.Limit)
+	case errors.As(err, &network):
+		return format(`Unable to reach %s, check the address or try again later.`, network.URL)
+	case errors.As(err, &status):
+		switch {
+		case status.StatusCode == http.StatusUnauthorized || status.StatusCode == http.StatusForbidden:
+			return format(`%s answered %s, the feed may be private.`, status.URL, status.Status)
+		case status.StatusCode == http.StatusNotFound || status.StatusCode == http.StatusGone:
+			return format(`%s answered %s, check the address.`, status.URL, status.Status)
+		case status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= http.StatusInternalServerError:
+			return format(`%s answered %s, try again later.`, status.URL, status.Status)
+		default:
+			return format(`%s answered %s.`, status.URL, status.Status)
+		}
+	case errors.As(err, &notFeed):
+		return format(`%s is not a feed and no feed was found on it, send the address of the feed itself.`, notFeed.URL)
+	case errors.As(err, &tooLarge):
+		return format(`%s is larger than %d MB, feeds this large are not supported.`, tooLarge.URL, tooLarge.Limit>>20)
+	case errors.As(err, &parse):
+		return format("The feed at %s is malformed: %s", parse.URL, code(parse.Err.Error()))
+	case errors.As(err, &storage):
+		return format(`Unable to save the subscription, please try again later.`)
+	default:
+		return format(`Subscribe failed.`)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDigestTime  = "08:00"
+	defaultDigestLimit = 20
+)
+
+// Next returns the first digest time after now, the day starts over at
+// midnight for intervals that don't divide it.
+func (digest Digest) Next(now time.Time, location *time.Location) time.Time {
+	now = now.In(location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, location)
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, location)
+
+	if digest.Interval > 0 {
+		step := time.Duration(digest.Interval) * time.Minute
+		next := midnight.Add(now.Sub(midnight).Truncate(step) + step)
+		if next.After(tomorrow) {
+			next = tomorrow
+		}
+		return next
+	}
+
+	times := digest.Times
+	if len(times) == 0 {
+		times = []string{defaultDigestTime}
+	}
+
+	var next time.Time
+	for _, clock := range times {
+		hour, minute, err := parseClock(clock)
+		if err != nil {
+			continue
+		}
+
+		candidate := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, location)
+		if !candidate.After(now) {
+			candidate = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, location)
+		}
+		if next.IsZero() || candidate.Before(next) {
+			next = candidate
+		}
+	}
+
+	return next
+}
+
+func (digest Digest) String() string {
+	var schedule string
+	switch {
+	case digest.Interval == 60:
+		schedule = "hourly"
+	case digest.Interval > 0:
+		schedule = fmt.Sprintf("every %dh%02dm", digest.Interval/60, digest.Interval%60)
+	case len(digest.Times) > 0:
+		schedule = fmt.Sprintf("daily at %s", strings.Join(digest.Times, ", "))
+	default:
+		schedule = fmt.Sprintf("daily at %s", defaultDigestTime)
+	}
+
+	limit := digest.Limit
+	if limit <= 0 {
+		limit = defaultDigestLimit
+	}
+
+	return fmt.Sprintf("%s, up to %d items", schedule, limit)
+}
+
+func parseClock(clock string) (int, int, error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("invalid time %s, use HH:MM", clock)
+	}
+
+	return hour, minute, nil
+}
+
+// digesting tells whether the items of the subscription wait for the digest,
+// the caller must hold the mutex.
+func (context *Context) digesting(subscription *Subscription) bool {
+	switch subscription.Delivery {
+	case DeliveryInstant:
+		return false
+	case DeliveryDigest:
+		return true
+	default:
+		return context.account.Digest.Enabled
+	}
+}
+
+// location is the timezone of the chat, the caller must hold the mutex.
+func (context *Context) location() *time.Location {
+	location, err := time.LoadLocation(context.account.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// NextDigest returns when the pending entries are due, zero when there are none.
+func (context *Context) NextDigest() time.Time {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	if len(context.digest) == 0 {
+		return time.Time{}
+	}
+
+	return context.account.Digest.Next(time.Now(), context.location())
+}
+
+// Compose moves the pending entries into the outbox as a single message, the
+// entries beyond the limit wait for the next digest.
+func (context *Context) Compose() error {
+	context.mutex.Lock()
+	pending := context.digest
+	if len(pending) == 0 {
+		context.mutex.Unlock()
+		return nil
+	}
+	context.digest = make([]*OutboxEntry, 0)
+
+	limit := context.account.Digest.Limit
+	if limit <= 0 {
+		limit = defaultDigestLimit
+	}
+
+	included := pending
+	if len(included) > limit {
+		included = included[:limit]
+	}
+	context.digest = append(context.digest, pending[len(included):]...)
+
+	order := make([]string, 0)
+	groups := make(map[string][]*OutboxEntry)
+	for _, entry := range included {
+		if groups[entry.SubscriptionId] == nil {
+			order = append(order, entry.SubscriptionId)
+		}
+		groups[entry.SubscriptionId] = append(groups[entry.SubscriptionId], entry)
+	}
+
+	message := format("%s\n", bold(fmt.Sprintf("Digest: %d new items", len(pending))))
+	for _, id := range order {
+		message += "\n"
+		if subscription := context.subscriptions[id]; subscription != nil {
+			message += format("%s\n", link(subscription.Title, subscription.Link))
+		}
+		for _, entry := range groups[id] {
+			message += format("• %s\n", Markup(entry.markup(SharedFormatter())))
+		}
+	}
+	if len(pending) > limit {
+		message += format("\n…and %d more in the next digest.", len(pending)-limit)
+	}
+	context.mutex.Unlock()
+
+	sequence := time.Now().UnixNano()
+	err := context.Enqueue([]*OutboxEntry{{
+		Id:        fmt.Sprintf("digest-%d", sequence),
+		Message:   message,
+		Sequence:  sequence,
+		ParseMode: SharedFormatter().Mode(),
+	}})
+	if err != nil {
+		// Put the entries back so the next digest picks them up.
+		context.mutex.Lock()
+		context.digest = append(included, context.digest...)
+		context.mutex.Unlock()
+		return err
+	}
+
+	for _, entry := range included {
+		err := SharedStore().DeleteOutboxEntry(context.Account(), entry)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// release hands the pending entries of subscriptions no longer in digest mode
+// to the outbox.
+func (context *Context) release() error {
+	context.mutex.Lock()
+	released := make([]*OutboxEntry, 0)
+	remaining := make([]*OutboxEntry, 0, len(context.digest))
+	for _, entry := range context.digest {
+		subscription := context.subscriptions[entry.SubscriptionId]
+		if subscription != nil && !context.digesting(subscription) {
+			released = append(released, entry)
+		} else {
+			remaining = append(remaining, entry)
+		}
+	}
+	context.digest = remaining
+	context.mutex.Unlock()
+
+	if len(released) == 0 {
+		context.Wake()
+		return nil
+	}
+
+	for _, entry := range released {
+		entry.Digest = false
+	}
+
+	return context.Enqueue(released)
+}
+
+func (context *Context) GetDigest() (Digest, int) {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	digest := context.account.Digest
+	digest.Times = append([]string{}, digest.Times...)
+
+	return digest, len(context.digest)
+}
+
+func (context *Context) Location() *time.Location {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.location()
+}
+
+func (context *Context) SetDigest(digest Digest) error {
+	err := context.updateAccount(func(account *Account) {
+		account.Digest = digest
+	})
+	if err != nil {
+		return err
+	}
+
+	return context.release()
+}
+
+func (context *Context) SetTimezone(timezone string) error {
+	err := context.updateAccount(func(account *Account) {
+		account.Timezone = timezone
+	})
+	if err != nil {
+		return err
+	}
+
+	// The delivery worker picks the new digest time up.
+	context.Wake()
+
+	return nil
+}
+
+func (context *Context) SetDelivery(subscription *Subscription, delivery string) error {
+	err := context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.Delivery = delivery
+	})
+	if err != nil {
+		return err
+	}
+
+	return context.release()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	listPageSize = 10
+	listRowSize  = 5
+	maxOffers    = 100
+)
+
+// ListPage renders a page of the subscriptions in the view of the last /list,
+// with a button opening each of them.
+func (context *Context) ListPage(page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	view := context.GetListView()
+	entries := context.ListEntries(view)
+	if len(entries) == 0 {
+		if len(view.Query) > 0 {
+			return format(`No subscriptions match %s.`, code(view.Query)), nil
+		}
+		return format(`Your list is empty.`), nil
+	}
+
+	pages := (len(entries) + listPageSize - 1) / listPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	start := page * listPageSize
+	end := start + listPageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	header := fmt.Sprintf("Subscriptions %d-%d of %d", start+1, end, len(entries))
+	if len(view.Query) > 0 {
+		header += fmt.Sprintf(" matching %q", view.Query)
+	}
+	if len(view.Sort) > 0 && view.Sort != SortAdded {
+		header += ", by " + view.Sort
+	}
+	message := format("%s:\n", header)
+
+	location := context.Location()
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	row := make([]tgbotapi.InlineKeyboardButton, 0)
+	for _, entry := range entries[start:end] {
+		subscription := entry.Subscription
+
+		last := "no items yet"
+		if !entry.LastItem.IsZero() {
+			last = "last item " + entry.LastItem.In(location).Format("2006-01-02 15:04")
+		}
+		message += format("%d. %s\n%s, %d in 30 days\n", entry.Index, link(subscription.Title, subscription.Link), last, entry.Activity)
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(entry.Index), context.callback("s", page, subscription.Id)))
+		if len(row) == listRowSize {
+			rows = append(rows, row)
+			row = make([]tgbotapi.InlineKeyboardButton, 0)
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	navigation := make([]tgbotapi.InlineKeyboardButton, 0)
+	if page > 0 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("« Previous", context.callback("l", page-1)))
+	}
+	if page < pages-1 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("Next »", context.callback("l", page+1)))
+	}
+	if len(navigation) > 0 {
+		rows = append(rows, navigation)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return message, &keyboard
+}
+
+// SubscriptionPage renders the settings of a subscription with buttons to change them.
+func (context *Context) SubscriptionPage(subscription *Subscription, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	context.mutex.Lock()
+	snapshot := *context.current(subscription)
+	location := context.location()
+	context.mutex.Unlock()
+
+	status := "active"
+	if snapshot.Paused {
+		status = "paused"
+	} else if snapshot.MutedUntil > time.Now().Unix() {
+		status = "muted until " + time.Unix(snapshot.MutedUntil, 0).In(location).Format("2006-01-02 15:04 MST")
+	}
+
+	delivery := snapshot.Delivery
+	if len(delivery) == 0 {
+		delivery = "as the chat"
+	}
+
+	message := format("%s\n\nSubscribed: %s\nStatus: %s\nFilters: %d\nDelivery: %s",
+		link(snapshot.Title, snapshot.Link),
+		time.Unix(snapshot.Timestamp, 0).In(location).Format("2006-01-02 15:04 MST"),
+		status,
+		len(snapshot.Filters),
+		delivery,
+	)
+	if len(snapshot.Tags) > 0 {
+		message += format("\nTags: %s", strings.Join(snapshot.Tags, ", "))
+	}
+
+	pause := "Pause"
+	if status != "active" {
+		pause = "Resume"
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Unsubscribe", context.callback("u", page, snapshot.Id)),
+			tgbotapi.NewInlineKeyboardButtonData(pause, context.callback("p", page, snapshot.Id)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Filters", context.callback("f", page, snapshot.Id)),
+			tgbotapi.NewInlineKeyboardButtonData("Preview", context.callback("v", page, snapshot.Id)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Back", context.callback("l", page)),
+		),
+	)
+
+	return message, &keyboard
+}
+
+// FilterPage lists the filters of a subscription.
+func (context *Context) FilterPage(subscription *Subscription, page int) (string, *tgbotapi.InlineKeyboardMarkup) {
+	message := context.HandleFilterCommand(subscription.Link)
+	message += format("\n\nSend %s to change them.", code("/filter "+subscription.Link))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("« Back", context.callback("s", page, subscription.Id)),
+		),
+	)
+
+	return message, &keyboard
+}
+
+// ChoicePage offers the feeds found on a web page, a button subscribes to each.
+func (context *Context) ChoicePage(choice *FeedChoiceError) (string, *tgbotapi.InlineKeyboardMarkup) {
+	message := format("%s has several feeds, which one do you want to subscribe to?\n", choice.URL)
+
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0)
+	for index, feed := range choice.Feeds {
+		message += format("%d. %s\n", index+1, link(feed.Title, feed.URL))
+
+		title := []rune(feed.Title)
+		if len(title) > 40 {
+			title = append(title[:40], '…')
+		}
+		text := strconv.Itoa(index+1) + ". " + string(title)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, context.callback("a", 0, context.offer(feed.URL))),
+		))
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return message, &keyboard
+}
+
+// offer remembers a feed the user may pick, the key fits in callback data
+// where the url may not.
+func (context *Context) offer(url string) string {
+	key := fmt.Sprintf("%x", md5.Sum([]byte(url)))
+
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	// Offers are only kept in memory, old ones are dropped now and then.
+	if len(context.choices) >= maxOffers {
+		context.choices = make(map[string]string)
+	}
+	context.choices[key] = url
+
+	return key
+}
+
+// Offered returns the url of a feed offered earlier, empty when it is no longer known.
+func (context *Context) Offered(key string) string {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.choices[key]
+}
+
+// callback encodes a button as "<action>:<chat id>:<page>[:<subscription id or offer>]".
+// The chat is the one managed, it differs from the chat of the message when a
+// channel is managed remotely.
+func (context *Context) callback(action string, page int, args ...string) string {
+	fields := append([]string{action, strconv.FormatInt(context.id, 10), strconv.Itoa(page)}, args...)
+	return strings.Join(fields, ":")
+}
+
+func (context *Context) GetSubscription(id string) *Subscription {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.subscriptions[id]
+}
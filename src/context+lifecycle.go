@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+)
+
+// HandleMembership follows the bot being blocked, kicked or added back to a chat.
+func HandleMembership(update *ChatMemberUpdated) error {
+	switch update.NewChatMember.Status {
+	case "left", "kicked":
+		// A chat the bot never served has nothing to deactivate.
+		context := FindContext(update.Chat.ID)
+		if context == nil {
+			return nil
+		}
+		return context.Deactivate()
+	default:
+		context, err := NewContext(update.Chat.ID, chatKind(&update.Chat))
+		if err != nil {
+			return err
+		}
+		return context.Activate()
+	}
+}
+
+// Deactivate stops observing the feeds of a chat the bot can no longer reach,
+// its subscriptions, caches and pending messages are kept for when it is back.
+func (context *Context) Deactivate() error {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	if context.account.Inactive {
+		return nil
+	}
+
+	err := context.swapAccount(func(account *Account) {
+		account.Inactive = true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range context.subscriptions {
+		context.StopObserving(subscription)
+	}
+
+	log.Printf("Context %d deactivated", context.id)
+
+	return nil
+}
+
+// Activate observes the feeds of the chat again and resumes its outbox.
+func (context *Context) Activate() error {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	if !context.account.Inactive {
+		return nil
+	}
+
+	err := context.swapAccount(func(account *Account) {
+		account.Inactive = false
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range context.subscriptions {
+		context.StartObserving(subscription)
+	}
+	context.Wake()
+
+	log.Printf("Context %d activated", context.id)
+
+	return nil
+}
+
+// MigrateContext moves the settings, subscriptions, caches and the outbox of a
+// group to the supergroup it was upgraded to.
+func MigrateContext(from int64, to int64) error {
+	contextsMutex.Lock()
+	defer contextsMutex.Unlock()
+
+	source := contexts[from]
+	if source == nil {
+		return nil
+	}
+
+	settings := source.Account()
+	target, err := newContext(to, settings.Kind)
+	if err != nil {
+		return err
+	}
+
+	// The supergroup keeps the settings of the group, its permissions first.
+	err = target.updateAccount(func(account *Account) {
+		account.Permission = settings.Permission
+		account.Allowlist = append([]int64{}, settings.Allowlist...)
+		account.Timezone = settings.Timezone
+		account.Digest = settings.Digest
+		account.Digest.Times = append([]string{}, settings.Digest.Times...)
+		account.Paused = settings.Paused
+		account.MutedUntil = settings.MutedUntil
+		account.Quiet = settings.Quiet
+		account.Template = settings.Template
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nothing may touch the old chat while it is being moved.
+	source.Stop()
+	for _, subscription := range source.GetSubscriptions() {
+		source.StopObserving(subscription)
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	for id, subscription := range source.subscriptions {
+		target.mutex.Lock()
+		exists := target.subscriptions[id] != nil
+		target.mutex.Unlock()
+
+		if !exists {
+			cache := copyCache(source.caches[id])
+
+			err = SharedStore().AddSubscription(target.Account(), subscription)
+			if err != nil {
+				return err
+			}
+			err = SharedStore().SetFeedCache(target.Account(), subscription, cache)
+			if err != nil {
+				return err
+			}
+
+			target.mutex.Lock()
+			target.subscriptions[id] = subscription
+			target.caches[id] = cache
+			if !target.account.Inactive {
+				target.StartObserving(subscription)
+			}
+			target.mutex.Unlock()
+		}
+
+		err = SharedStore().DeleteSubscription(source.account, subscription)
+		if err != nil {
+			return err
+		}
+		err = SharedStore().DeleteFeedCache(source.account, subscription)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The failed entries get another chance in the supergroup.
+	for _, entry := range source.failed {
+		entry.Failed = false
+		entry.attempts = 0
+	}
+	if pending := append(append(source.outbox, source.digest...), source.failed...); len(pending) > 0 {
+		err = target.Enqueue(pending)
+		if err != nil {
+			return err
+		}
+		for _, entry := range pending {
+			err = SharedStore().DeleteOutboxEntry(source.account, entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = SharedStore().DeleteAccount(source.account)
+	if err != nil {
+		return err
+	}
+	delete(contexts, from)
+
+	log.Printf("Context %d migrated to %d", from, to)
+
+	return nil
+}
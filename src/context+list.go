@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// How /list orders the subscriptions.
+const (
+	SortAdded    = "added"
+	SortTitle    = "title"
+	SortUpdated  = "updated"
+	SortActivity = "activity"
+)
+
+var listSorts = []string{SortAdded, SortTitle, SortUpdated, SortActivity}
+
+// Items published within this period count towards the activity of a subscription.
+const activityPeriod = 30 * 24 * time.Hour
+
+// ListView is the search and order of the last /list of the chat, the page
+// buttons keep to it.
+type ListView struct {
+	Query string
+	Sort  string
+}
+
+// ListEntry is a subscription as /list shows it, Index is the one commands take.
+type ListEntry struct {
+	Index        int
+	Subscription *Subscription
+	LastItem     time.Time
+	Activity     int
+}
+
+func (context *Context) GetListView() ListView {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.view
+}
+
+func (context *Context) SetListView(view ListView) {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	context.view = view
+}
+
+// ListEntries returns the subscriptions matching the query of the view in its
+// order, the query searches the titles, links and tags.
+func (context *Context) ListEntries(view ListView) []*ListEntry {
+	subscriptions := context.GetSubscriptions()
+	query := strings.ToLower(view.Query)
+	since := time.Now().Add(-activityPeriod).Unix()
+
+	context.mutex.Lock()
+	entries := make([]*ListEntry, 0, len(subscriptions))
+	for index, subscription := range subscriptions {
+		if len(query) > 0 && !matches(subscription, query) {
+			continue
+		}
+
+		entry := &ListEntry{
+			Index:        index + 1,
+			Subscription: subscription,
+		}
+
+		var last int64
+		for _, value := range context.caches[subscription.Id] {
+			// Caches written before the publication date was kept only have the
+			// time the item was seen.
+			published := cacheTime(value, "published")
+			if published == 0 {
+				published = cacheTime(value, "timestamp")
+			}
+			if published > last {
+				last = published
+			}
+			if published >= since {
+				entry.Activity++
+			}
+		}
+		if last > 0 {
+			entry.LastItem = time.Unix(last, 0)
+		}
+
+		entries = append(entries, entry)
+	}
+	context.mutex.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		switch view.Sort {
+		case SortTitle:
+			return strings.ToLower(entries[i].Subscription.Title) < strings.ToLower(entries[j].Subscription.Title)
+		case SortUpdated:
+			return entries[i].LastItem.After(entries[j].LastItem)
+		case SortActivity:
+			return entries[i].Activity > entries[j].Activity
+		default:
+			return entries[i].Index < entries[j].Index
+		}
+	})
+
+	return entries
+}
+
+// matches tells whether the title, the link or a tag contains the lowercase query.
+func matches(subscription *Subscription, query string) bool {
+	if strings.Contains(strings.ToLower(subscription.Title), query) ||
+		strings.Contains(strings.ToLower(subscription.Link), query) {
+		return true
+	}
+
+	for _, tag := range subscription.Tags {
+		if strings.Contains(strings.ToLower(tag), query) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheTime reads a timestamp of a cache entry, the stores decode numbers differently.
+func cacheTime(value interface{}, key string) int64 {
+	entry, ok := value.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	switch number := entry[key].(type) {
+	case int64:
+		return number
+	case int:
+		return int64(number)
+	case float64:
+		return int64(number)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strconv"
+)
+
+// ExportOPML writes the subscriptions of the chat as an OPML document.
+func (context *Context) ExportOPML() ([]byte, error) {
+	subscriptions := context.GetSubscriptions()
+
+	context.mutex.Lock()
+	snapshots := make([]*Subscription, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		snapshot := *subscription
+		snapshots = append(snapshots, &snapshot)
+	}
+	context.mutex.Unlock()
+
+	return NewOPML("Subscriptions of "+strconv.FormatInt(context.id, 10), snapshots)
+}
+
+// ImportFeed subscribes to a feed of an OPML document.
+func (context *Context) ImportFeed(feed *OPMLFeed) (*Subscription, error) {
+	if !isValidURL(feed.URL) {
+		return nil, &InvalidURLError{URL: feed.URL}
+	}
+	if subscription := context.FindSubscription(feed.URL); subscription != nil {
+		return nil, &DuplicateError{Subscription: subscription}
+	}
+	if err := context.CheckLimit(); err != nil {
+		return nil, err
+	}
+
+	channel, items, err := FetchChannel(feed.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.SubscribeChannel(channel, items, feed.Tags)
+}
+
+func (context *Context) SetTags(subscription *Subscription, tags []string) error {
+	return context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.Tags = tags
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	outboxRetryDelay = 30 * time.Second
+	// maxFailed caps the failed entries kept for /failed, the oldest go first.
+	maxFailed = 20
+)
+
+// Enqueue persists the entries and hands them to the delivery worker, or keeps
+// them for the digest. Entries already waiting are skipped.
+func (context *Context) Enqueue(entries []*OutboxEntry) error {
+	err := SharedStore().AddOutboxEntries(context.Account(), entries)
+	if err != nil {
+		return err
+	}
+
+	context.mutex.Lock()
+	pending := make(map[string]bool)
+	for _, entry := range context.outbox {
+		pending[entry.Id] = true
+	}
+	for _, entry := range context.digest {
+		pending[entry.Id] = true
+	}
+	for _, entry := range context.failed {
+		pending[entry.Id] = true
+	}
+	for _, entry := range entries {
+		if pending[entry.Id] {
+			continue
+		}
+		if entry.Digest {
+			context.digest = append(context.digest, entry)
+		} else {
+			context.outbox = append(context.outbox, entry)
+		}
+		pending[entry.Id] = true
+	}
+	context.mutex.Unlock()
+
+	context.Wake()
+
+	return nil
+}
+
+func (context *Context) Wake() {
+	select {
+	case context.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver drains the outbox until the context is stopped, an entry only
+// leaves the outbox once Telegram accepted it. Pending digest entries are
+// composed into the outbox when the digest is due, held messages are sent
+// when the quiet hours end.
+func (context *Context) Deliver() {
+	defer close(context.done)
+
+	for {
+		digest, due := after(context.NextDigest())
+		quiet, resume := after(context.QuietEnd())
+
+		select {
+		case <-context.quit:
+		case <-context.wake:
+			context.drain()
+		case <-due:
+			err := context.Compose()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-resume:
+			context.drain()
+		}
+
+		for _, timer := range []*time.Timer{digest, quiet} {
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+
+		select {
+		case <-context.quit:
+			return
+		default:
+		}
+	}
+}
+
+// after returns a timer firing at the given time, none for the zero time.
+func after(at time.Time) (*time.Timer, <-chan time.Time) {
+	if at.IsZero() {
+		return nil, nil
+	}
+
+	timer := time.NewTimer(time.Until(at))
+	return timer, timer.C
+}
+
+func (context *Context) Stop() {
+	select {
+	case <-context.quit:
+	default:
+		close(context.quit)
+	}
+	<-context.done
+}
+
+func (context *Context) drain() {
+	for {
+		select {
+		case <-context.quit:
+			return
+		default:
+		}
+
+		context.mutex.Lock()
+		if len(context.outbox) == 0 {
+			context.mutex.Unlock()
+			return
+		}
+		entry := context.outbox[0]
+		quiet, hold, _ := context.quiet()
+		inactive := context.account.Inactive
+		context.mutex.Unlock()
+
+		// The outbox of a chat the bot can't reach waits until it is back.
+		if inactive {
+			return
+		}
+
+		// Held messages are picked up again when the quiet hours end.
+		if quiet && hold {
+			return
+		}
+
+		err := session.SendEntry(context.id, entry, quiet)
+		if to := migratedChatID(err); to != 0 {
+			// Migrating stops this worker, it can't wait for itself.
+			go func() {
+				err := MigrateContext(context.id, to)
+				if err != nil {
+					log.Println(err)
+				}
+			}()
+			return
+		}
+		if isChatUnavailable(err) {
+			err := context.Deactivate()
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		if isRejected(err) {
+			context.reject(entry, err)
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+
+			// Telegram failing on the message repeatedly means it will never go through.
+			entry.attempts++
+			if _, ok := err.(tgbotapi.Error); ok && entry.attempts >= maxRetries {
+				context.reject(entry, err)
+				continue
+			}
+			time.AfterFunc(outboxRetryDelay, context.Wake)
+			return
+		}
+
+		context.ack(entry)
+	}
+}
+
+// reject moves an entry Telegram won't take out of the outbox, it is kept
+// aside as failed for /failed instead of being retried or lost.
+func (context *Context) reject(entry *OutboxEntry, err error) {
+	log.Printf("Chat %d rejected outbox entry %s, keeping it aside: %v", context.id, entry.Id, err)
+
+	entry.Failed = true
+	err = SharedStore().AddOutboxEntries(context.Account(), []*OutboxEntry{entry})
+	if err != nil {
+		log.Println(err)
+	}
+
+	context.mutex.Lock()
+	context.failed = append(context.failed, entry)
+	var dropped []*OutboxEntry
+	if excess := len(context.failed) - maxFailed; excess > 0 {
+		dropped = context.failed[:excess]
+		context.failed = append([]*OutboxEntry{}, context.failed[excess:]...)
+	}
+	account := context.account
+	context.mutex.Unlock()
+
+	for _, entry := range dropped {
+		err := SharedStore().DeleteOutboxEntry(account, entry)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	context.ack(entry)
+}
+
+// GetFailed returns the entries Telegram refused, oldest first.
+func (context *Context) GetFailed() []*OutboxEntry {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return append([]*OutboxEntry{}, context.failed...)
+}
+
+// RetryFailed hands the failed entries back to the outbox.
+func (context *Context) RetryFailed() error {
+	context.mutex.Lock()
+	failed := context.failed
+	context.failed = make([]*OutboxEntry, 0)
+	context.mutex.Unlock()
+
+	for _, entry := range failed {
+		entry.Failed = false
+		entry.attempts = 0
+	}
+
+	return context.Enqueue(failed)
+}
+
+// ClearFailed forgets the failed entries.
+func (context *Context) ClearFailed() error {
+	context.mutex.Lock()
+	failed := context.failed
+	context.failed = make([]*OutboxEntry, 0)
+	account := context.account
+	context.mutex.Unlock()
+
+	for _, entry := range failed {
+		err := SharedStore().DeleteOutboxEntry(account, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// markup returns the message of the entry in the markup of the formatter, as
+// plain text when it was rendered for another parse mode.
+func (entry *OutboxEntry) markup(formatter Formatter) string {
+	if entry.ParseMode == formatter.Mode() {
+		return entry.Message
+	}
+	return formatter.Escape(FormatterFor(entry.ParseMode).Plain(entry.Message))
+}
+
+// ack removes a delivered entry from the outbox, failed entries stay stored.
+func (context *Context) ack(entry *OutboxEntry) {
+	if !entry.Failed {
+		err := SharedStore().DeleteOutboxEntry(context.Account(), entry)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	context.mutex.Lock()
+	for index, pending := range context.outbox {
+		if pending == entry {
+			context.outbox = append(context.outbox[:index], context.outbox[index+1:]...)
+			break
+		}
+	}
+	context.mutex.Unlock()
+}
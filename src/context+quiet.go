@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Active tells whether now falls into the quiet hours and when they end.
+func (quiet Quiet) Active(now time.Time, location *time.Location) (bool, time.Time) {
+	if len(quiet.Start) == 0 || len(quiet.End) == 0 {
+		return false, time.Time{}
+	}
+
+	startHour, startMinute, err := parseClock(quiet.Start)
+	if err != nil {
+		return false, time.Time{}
+	}
+	endHour, endMinute, err := parseClock(quiet.End)
+	if err != nil {
+		return false, time.Time{}
+	}
+
+	now = now.In(location)
+	minutes := now.Hour()*60 + now.Minute()
+	start, end := startHour*60+startMinute, endHour*60+endMinute
+
+	// Quiet hours may run past midnight, such as 22:00 to 07:00.
+	var active bool
+	if start <= end {
+		active = minutes >= start && minutes < end
+	} else {
+		active = minutes >= start || minutes < end
+	}
+	if !active {
+		return false, time.Time{}
+	}
+
+	until := time.Date(now.Year(), now.Month(), now.Day(), endHour, endMinute, 0, 0, location)
+	if !until.After(now) {
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, endHour, endMinute, 0, 0, location)
+	}
+
+	return true, until
+}
+
+func (quiet Quiet) String() string {
+	if len(quiet.Start) == 0 {
+		return "off"
+	}
+
+	mode := "sent silently"
+	if quiet.Hold {
+		mode = "held until they end"
+	}
+
+	return fmt.Sprintf("%s to %s, messages are %s", quiet.Start, quiet.End, mode)
+}
+
+// suppressed tells whether new items of the subscription are skipped, the
+// caller must hold the mutex.
+func (context *Context) suppressed(subscription *Subscription) bool {
+	now := time.Now().Unix()
+
+	return context.account.Paused || subscription.Paused ||
+		now < context.account.MutedUntil || now < subscription.MutedUntil
+}
+
+// IsPaused tells whether the subscription itself is paused or muted.
+func (context *Context) IsPaused(subscription *Subscription) bool {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	subscription = context.current(subscription)
+
+	return subscription.Paused || subscription.MutedUntil > time.Now().Unix()
+}
+
+// quiet tells whether the chat is in its quiet hours, the caller must hold the mutex.
+func (context *Context) quiet() (bool, bool, time.Time) {
+	active, until := context.account.Quiet.Active(time.Now(), context.location())
+
+	return active, context.account.Quiet.Hold, until
+}
+
+// QuietEnd returns when held messages are due, zero when nothing is held.
+func (context *Context) QuietEnd() time.Time {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	if len(context.outbox) == 0 {
+		return time.Time{}
+	}
+
+	active, hold, until := context.quiet()
+	if !active || !hold {
+		return time.Time{}
+	}
+
+	return until
+}
+
+func (context *Context) GetQuiet() (Quiet, bool, int64) {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.account.Quiet, context.account.Paused, context.account.MutedUntil
+}
+
+func (context *Context) SetQuiet(quiet Quiet) error {
+	err := context.updateAccount(func(account *Account) {
+		account.Quiet = quiet
+	})
+	if err != nil {
+		return err
+	}
+
+	// Messages held by the previous quiet hours may be due now.
+	context.Wake()
+
+	return nil
+}
+
+// SetPaused pauses or resumes the subscription, or the whole chat when it is nil.
+// Resuming also lifts a mute.
+func (context *Context) SetPaused(subscription *Subscription, paused bool) error {
+	if subscription == nil {
+		return context.updateAccount(func(account *Account) {
+			account.Paused = paused
+			if !paused {
+				account.MutedUntil = 0
+			}
+		})
+	}
+
+	return context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.Paused = paused
+		if !paused {
+			subscription.MutedUntil = 0
+		}
+	})
+}
+
+// SetMuted mutes the subscription, or the whole chat when it is nil, until the given time.
+func (context *Context) SetMuted(subscription *Subscription, until int64) error {
+	if subscription == nil {
+		return context.updateAccount(func(account *Account) {
+			account.MutedUntil = until
+		})
+	}
+
+	return context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.MutedUntil = until
+	})
+}
@@ -4,9 +4,9 @@ import (
 	"fmt"
 	"log"
 	"sort"
+	"strconv"
+	"sync"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
 type Context struct {
@@ -14,10 +14,20 @@ type Context struct {
 	account       *Account
 	subscriptions map[string]*Subscription
 	caches        map[string]map[string]interface{}
+	dirty         map[string]bool
+	outbox        []*OutboxEntry
+	digest        []*OutboxEntry
+	failed        []*OutboxEntry
+	view          ListView
+	choices       map[string]string
+	mutex         sync.Mutex
+	wake          chan struct{}
+	quit          chan struct{}
+	done          chan struct{}
 }
 
 func InitContents() error {
-	accounts, err := SharedFirebase().GetAccounts()
+	accounts, err := SharedStore().GetAccounts()
 	if err != nil {
 		return err
 	}
@@ -32,7 +42,39 @@ func InitContents() error {
 	return nil
 }
 
+// StopContexts waits for the deliveries in progress and writes out the caches
+// that failed to persist earlier.
+func StopContexts() {
+	contextsMutex.Lock()
+	defer contextsMutex.Unlock()
+
+	for _, context := range contexts {
+		context.Stop()
+
+		err := context.Flush()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// FindContext returns the context of a chat the bot already knows, nil otherwise.
+func FindContext(id int64) *Context {
+	contextsMutex.Lock()
+	defer contextsMutex.Unlock()
+
+	return contexts[id]
+}
+
 func NewContext(id int64, kind int) (*Context, error) {
+	contextsMutex.Lock()
+	defer contextsMutex.Unlock()
+
+	return newContext(id, kind)
+}
+
+// newContext loads or creates the context, the caller must hold contextsMutex.
+func newContext(id int64, kind int) (*Context, error) {
 	context := contexts[id]
 	if context != nil {
 		return context, nil
@@ -42,9 +84,17 @@ func NewContext(id int64, kind int) (*Context, error) {
 		id:            id,
 		subscriptions: make(map[string]*Subscription),
 		caches:        make(map[string]map[string]interface{}),
+		dirty:         make(map[string]bool),
+		outbox:        make([]*OutboxEntry, 0),
+		digest:        make([]*OutboxEntry, 0),
+		failed:        make([]*OutboxEntry, 0),
+		choices:       make(map[string]string),
+		wake:          make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 
-	account, err := SharedFirebase().GetAccount(id)
+	account, err := SharedStore().GetAccount(id)
 	if err != nil {
 		return nil, err
 	}
@@ -53,20 +103,20 @@ func NewContext(id int64, kind int) (*Context, error) {
 			Id:   id,
 			Kind: kind,
 		}
-		err = SharedFirebase().SaveAccount(account)
+		err = SharedStore().SaveAccount(account)
 		if err != nil {
 			return nil, err
 		}
 	}
 	context.account = account
 
-	if subscriptions, err := SharedFirebase().GetSubscriptions(account); err != nil {
+	if subscriptions, err := SharedStore().GetSubscriptions(account); err != nil {
 		return nil, err
 	} else {
 		for id, subscription := range subscriptions {
 			context.subscriptions[id] = subscription
 
-			cache, err := SharedFirebase().GetFeedCache(account, subscription)
+			cache, err := SharedStore().GetFeedCache(account, subscription)
 			if err != nil {
 				return nil, err
 			}
@@ -74,15 +124,34 @@ func NewContext(id int64, kind int) (*Context, error) {
 		}
 	}
 
-	for _, subscription := range context.subscriptions {
-		err = context.StartObserving(subscription)
-		if err != nil {
-			return nil, err
+	entries, err := SharedStore().GetOutbox(account)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Failed {
+			context.failed = append(context.failed, entry)
+		} else if entry.Digest {
+			context.digest = append(context.digest, entry)
+		} else {
+			context.outbox = append(context.outbox, entry)
+		}
+	}
+
+	if !account.Inactive {
+		for _, subscription := range context.subscriptions {
+			err = context.StartObserving(subscription)
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	contexts[account.Id] = context
 
+	go context.Deliver()
+	context.Wake()
+
 	return context, nil
 }
 
@@ -94,42 +163,95 @@ func (context *Context) StartObserving(subscription *Subscription) error {
 				return
 			}
 
-			old := make(map[string]interface{})
-			new := make(map[string]interface{})
+			old := make([]string, 0)
+			entries := make([]*OutboxEntry, 0)
+			sequence := time.Now().UnixNano()
 
-			for id, cache := range context.caches[subscription.Id] {
+			context.mutex.Lock()
+			caches := context.caches[subscription.Id]
+			if caches == nil {
+				context.mutex.Unlock()
+				return
+			}
+			// The settings may have changed since observing started.
+			current := context.current(subscription)
+			for id := range caches {
 				if items[id] == nil {
-					old[id] = cache
+					old = append(old, id)
 				}
 			}
+			seen := make([]*Item, 0)
+			for _, item := range SortItems(items) {
+				if caches[item.id] != nil {
+					continue
+				}
 
-			for _, item := range items {
-				if context.caches[subscription.Id][item.id] == nil {
-					new[item.id] = map[string]interface{}{
-						"pushed":    true,
-						"timestamp": time.Now().Unix(),
-					}
-
-					msg := fmt.Sprintf("[%s](%s)", item.title, item.link)
-					err := session.Send(context.id, msg)
-					if err != nil {
-						log.Println(err)
-						return
-					}
+				// Filtered items, and those arriving while paused or muted, are
+				// still marked as seen so they never come up later.
+				seen = append(seen, item)
+				if !context.suppressed(current) && Admit(current.Filters, item) {
+					entries = append(entries, &OutboxEntry{
+						Id:             subscription.Id + "-" + item.id,
+						SubscriptionId: subscription.Id,
+						ItemId:         item.id,
+						Message:        context.render(current, item),
+						ParseMode:      SharedFormatter().Mode(),
+						Sequence:       sequence + int64(len(entries)),
+						Digest:         context.digesting(current),
+					})
 				}
 			}
+			context.mutex.Unlock()
 
-			if len(new) == 0 && len(old) == 0 {
+			if len(seen) == 0 && len(old) == 0 {
 				return
 			}
 
-			for id := range old {
-				delete(context.caches[subscription.Id], id)
+			// The outbox is persisted before the items are marked as seen, a crash
+			// in between only enqueues the same entries again.
+			if len(entries) > 0 {
+				err := context.Enqueue(entries)
+				if err != nil {
+					log.Println(err)
+					return
+				}
+			}
+
+			context.mutex.Lock()
+			caches = context.caches[subscription.Id]
+			if caches == nil {
+				context.mutex.Unlock()
+				return
+			}
+			for _, id := range old {
+				delete(caches, id)
+			}
+			for _, item := range seen {
+				caches[item.id] = cacheEntry(item)
+			}
+			snapshot := copyCache(caches)
+			context.mutex.Unlock()
+
+			context.saveFeedCache(subscription, snapshot)
+		},
+		failure: func(state *FeedState) {
+			since := "never"
+			if !state.lastSuccess.IsZero() {
+				since = state.lastSuccess.UTC().Format("2006-01-02 15:04 MST")
+			}
+
+			msg := format("%s failed to update %d times in a row.\nLast success: %s\nLast error: %s\n\nSend %s to unsubscribe.", link(subscription.Title, subscription.Link), state.failures, since, code(fmt.Sprint(state.lastError)), code("/delete "+subscription.Link))
+			err := session.Send(context.id, msg)
+			if err != nil {
+				log.Println(err)
 			}
-			for id, cache := range new {
-				context.caches[subscription.Id][id] = cache
+		},
+		recovery: func(state *FeedState) {
+			msg := format("%s is back to normal.", link(subscription.Title, subscription.Link))
+			err := session.Send(context.id, msg)
+			if err != nil {
+				log.Println(err)
 			}
-			SharedFirebase().SetFeedCache(context.account, subscription, context.caches[subscription.Id])
 		},
 	}
 	SharedMonitor().AddObserver(observer, subscription.Link)
@@ -144,11 +266,17 @@ func (context *Context) StopObserving(subscription *Subscription) error {
 }
 
 func (context *Context) Subscribe(channel *Channel) (*Subscription, error) {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
 	id := channel.id
 
 	subscription := context.subscriptions[id]
 	if subscription != nil {
-		return nil, fmt.Errorf(`Subscription [%s](%s) exists`, subscription.Title, subscription.Link)
+		return nil, &DuplicateError{Subscription: subscription}
+	}
+	if err := context.checkLimit(); err != nil {
+		return nil, err
 	}
 
 	subscription = &Subscription{
@@ -157,46 +285,156 @@ func (context *Context) Subscribe(channel *Channel) (*Subscription, error) {
 		Title:     channel.title,
 		Timestamp: time.Now().Unix(),
 	}
-	context.subscriptions[id] = subscription
 
+	err := SharedStore().AddSubscription(context.account, subscription)
+	if err != nil {
+		return nil, storageError("adding subscription", err)
+	}
+
+	context.subscriptions[id] = subscription
 	context.caches[id] = make(map[string]interface{})
 
-	err := fb.AddSubscription(context.account, subscription)
+	return subscription, nil
+}
+
+// SubscribeChannel subscribes to the fetched channel with the tags given and
+// starts observing it, the items fetched are marked as seen.
+func (context *Context) SubscribeChannel(channel *Channel, items []*Item, tags []string) (*Subscription, error) {
+	subscription, err := context.Subscribe(channel)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(tags) > 0 {
+		err = context.SetTags(subscription, tags)
+	}
+	if err == nil {
+		err = context.SetItemsPushed(subscription, items)
+	}
+	if err == nil {
+		err = context.StartObserving(subscription)
+	}
+	if err != nil {
+		// A half set up subscription would push the whole feed, take it back.
+		context.StopObserving(subscription)
+		if err := context.Unsubscribe(subscription); err != nil {
+			log.Printf("Chat %d failed to roll back %s: %v", context.id, subscription.Link, err)
+		}
+		return nil, err
+	}
+
 	return subscription, nil
 }
 
+// CheckLimit tells whether the chat may subscribe to another feed.
+func (context *Context) CheckLimit() error {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.checkLimit()
+}
+
+// checkLimit is CheckLimit for callers holding the mutex.
+func (context *Context) checkLimit() error {
+	if maxSubscriptions > 0 && len(context.subscriptions) >= maxSubscriptions {
+		return &LimitError{Limit: maxSubscriptions}
+	}
+	return nil
+}
+
 func (context *Context) Unsubscribe(subscription *Subscription) error {
-	err := fb.DeleteSubscription(context.account, subscription)
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	err := SharedStore().DeleteSubscription(context.account, subscription)
 	if err != nil {
 		return err
 	}
 	delete(context.subscriptions, subscription.Id)
 
-	err = fb.DeleteFeedCache(context.account, subscription)
+	err = SharedStore().DeleteFeedCache(context.account, subscription)
 	if err != nil {
 		return err
 	}
 	delete(context.caches, subscription.Id)
+	delete(context.dirty, subscription.Id)
+
+	context.outbox = context.dropEntries(context.outbox, subscription)
+	context.digest = context.dropEntries(context.digest, subscription)
+	context.failed = context.dropEntries(context.failed, subscription)
 
 	return err
 }
 
+// dropEntries deletes the entries of the subscription, the caller must hold the mutex.
+func (context *Context) dropEntries(entries []*OutboxEntry, subscription *Subscription) []*OutboxEntry {
+	remaining := make([]*OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.SubscriptionId != subscription.Id {
+			remaining = append(remaining, entry)
+		} else if err := SharedStore().DeleteOutboxEntry(context.account, entry); err != nil {
+			log.Println(err)
+		}
+	}
+	return remaining
+}
+
 func (context *Context) SetItemsPushed(subscription *Subscription, items []*Item) error {
+	context.mutex.Lock()
+	caches := context.caches[subscription.Id]
+	if caches == nil {
+		context.mutex.Unlock()
+		return fmt.Errorf(`Subscription [%s](%s) not found`, subscription.Title, subscription.Link)
+	}
 	for _, item := range items {
-		context.caches[subscription.Id][item.id] = map[string]interface{}{
-			"pushed":    true,
-			"timestamp": time.Now().Unix(),
+		caches[item.id] = cacheEntry(item)
+	}
+	snapshot := copyCache(caches)
+	context.mutex.Unlock()
+
+	return context.saveFeedCache(subscription, snapshot)
+}
+
+// saveFeedCache persists the cache, failed writes are remembered and retried by Flush.
+func (context *Context) saveFeedCache(subscription *Subscription, cache map[string]interface{}) error {
+	err := storageError("saving feed cache", SharedStore().SetFeedCache(context.Account(), subscription, cache))
+
+	context.mutex.Lock()
+	if err != nil {
+		log.Println(err)
+		context.dirty[subscription.Id] = true
+	} else {
+		delete(context.dirty, subscription.Id)
+	}
+	context.mutex.Unlock()
+
+	return err
+}
+
+func (context *Context) Flush() error {
+	context.mutex.Lock()
+	pending := make(map[*Subscription]map[string]interface{})
+	for id := range context.dirty {
+		if subscription := context.subscriptions[id]; subscription != nil {
+			pending[subscription] = copyCache(context.caches[id])
 		}
 	}
+	context.mutex.Unlock()
 
-	return SharedFirebase().SetFeedCache(context.account, subscription, context.caches[subscription.Id])
+	for subscription, cache := range pending {
+		err := context.saveFeedCache(subscription, cache)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (context *Context) GetSubscriptions() []*Subscription {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
 	subscriptions := make([]*Subscription, 0)
 	for _, subscription := range context.subscriptions {
 		subscriptions = append(subscriptions, subscription)
@@ -208,3 +446,175 @@ func (context *Context) GetSubscriptions() []*Subscription {
 
 	return subscriptions
 }
+
+// cacheEntry marks the item as seen, the publication date is kept for /list.
+func cacheEntry(item *Item) map[string]interface{} {
+	entry := map[string]interface{}{
+		"pushed":    true,
+		"timestamp": time.Now().Unix(),
+	}
+	if !item.published.IsZero() {
+		entry["published"] = item.published.Unix()
+	}
+	return entry
+}
+
+func copyCache(cache map[string]interface{}) map[string]interface{} {
+	snapshot := make(map[string]interface{}, len(cache))
+	for id, value := range cache {
+		snapshot[id] = value
+	}
+	return snapshot
+}
+
+func (context *Context) GetPermission() (int, []int64) {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	allowlist := append([]int64{}, context.account.Allowlist...)
+
+	return context.account.Permission, allowlist
+}
+
+func (context *Context) SetPermission(permission int, allowlist []int64) error {
+	return context.updateAccount(func(account *Account) {
+		account.Permission = permission
+		account.Allowlist = allowlist
+	})
+}
+
+// updateAccount persists a changed copy of the account before swapping it in.
+// Like subscriptions the account is never modified in place.
+func (context *Context) updateAccount(update func(account *Account)) error {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.swapAccount(update)
+}
+
+// swapAccount is updateAccount for callers holding the mutex.
+func (context *Context) swapAccount(update func(account *Account)) error {
+	account := *context.account
+	update(&account)
+
+	err := SharedStore().SaveAccount(&account)
+	if err != nil {
+		return err
+	}
+	context.account = &account
+
+	return nil
+}
+
+// Account returns the latest copy of the account, it may be read without the mutex.
+func (context *Context) Account() *Account {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return context.account
+}
+
+func (context *Context) GetFilters(subscription *Subscription) []*Filter {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	return append([]*Filter{}, context.current(subscription).Filters...)
+}
+
+func (context *Context) SetFilters(subscription *Subscription, filters []*Filter) error {
+	return context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.Filters = filters
+	})
+}
+
+// updateSubscription persists a changed copy of the subscription before
+// swapping it in. Subscriptions are never modified in place, so their fields
+// may be read without the mutex, an older copy only misses the latest settings.
+func (context *Context) updateSubscription(subscription *Subscription, update func(subscription *Subscription)) error {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	current := context.subscriptions[subscription.Id]
+	if current == nil {
+		return fmt.Errorf(`Subscription [%s](%s) not found`, subscription.Title, subscription.Link)
+	}
+
+	updated := *current
+	update(&updated)
+
+	err := SharedStore().SaveSubscription(context.account, &updated)
+	if err != nil {
+		return storageError("saving subscription", err)
+	}
+	context.subscriptions[updated.Id] = &updated
+
+	return nil
+}
+
+// current returns the latest copy of the subscription, the caller must hold the mutex.
+func (context *Context) current(subscription *Subscription) *Subscription {
+	if latest := context.subscriptions[subscription.Id]; latest != nil {
+		return latest
+	}
+	return subscription
+}
+
+// render formats the item with the template of the subscription, or of the
+// chat, the caller must hold the mutex.
+func (context *Context) render(subscription *Subscription, item *Item) string {
+	text := subscription.Template
+	if len(text) == 0 {
+		text = context.account.Template
+	}
+	if len(text) == 0 {
+		text = defaultTemplate
+	}
+
+	message, err := RenderItem(text, subscription, item, context.location())
+	if err != nil {
+		log.Printf("Context %d failed to render %s: %v", context.id, item.link, err)
+		message, _ = RenderItem(defaultTemplate, subscription, item, context.location())
+	}
+
+	return message
+}
+
+func (context *Context) GetTemplate(subscription *Subscription) string {
+	context.mutex.Lock()
+	defer context.mutex.Unlock()
+
+	if subscription != nil {
+		return subscription.Template
+	}
+	return context.account.Template
+}
+
+// SetTemplate sets the template of the subscription, or of the whole chat when it is nil.
+func (context *Context) SetTemplate(subscription *Subscription, text string) error {
+	if subscription == nil {
+		return context.updateAccount(func(account *Account) {
+			account.Template = text
+		})
+	}
+
+	return context.updateSubscription(subscription, func(subscription *Subscription) {
+		subscription.Template = text
+	})
+}
+
+// FindSubscription looks a subscription up by its position in the list or its link.
+func (context *Context) FindSubscription(args string) *Subscription {
+	subscriptions := context.GetSubscriptions()
+
+	if index, err := strconv.Atoi(args); err == nil && index > 0 && index <= len(subscriptions) {
+		return subscriptions[index-1]
+	}
+
+	for _, subscription := range subscriptions {
+		if subscription.Link == args {
+			return subscription
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// TestContextConcurrency subscribes and unsubscribes while the monitor pulls the
+// feeds into the observers and the settings are changed and rendered, run it
+// with -race.
+func TestContextConcurrency(t *testing.T) {
+	server := newFeedServer(t)
+	context := newTestContext(t, 1001)
+	monitor := SharedMonitor()
+
+	links := make([]string, 4)
+	for index := range links {
+		links[index] = fmt.Sprintf("%s/feed/context-%d", server.URL, index)
+	}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				monitor.Pull()
+			}
+		}
+	}()
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			for _, subscription := range context.GetSubscriptions() {
+				context.SetPaused(subscription, !context.IsPaused(subscription))
+				context.SetFilters(subscription, context.GetFilters(subscription))
+				context.SubscriptionPage(subscription, 0)
+			}
+			context.ListPage(0)
+			context.HandleFilterCommand(links[0])
+		}
+	}()
+
+	var subscribers sync.WaitGroup
+	for _, link := range links {
+		subscribers.Add(1)
+		go func(link string) {
+			defer subscribers.Done()
+			for round := 0; round < 20; round++ {
+				channel, items, err := FetchChannel(link)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				_, err = context.SubscribeChannel(channel, items, []string{"test"})
+				var duplicate *DuplicateError
+				if err != nil && !errors.As(err, &duplicate) {
+					t.Error(err)
+					return
+				}
+
+				// Leave the monitor some time to pull the feed.
+				time.Sleep(5 * time.Millisecond)
+				context.HandleUnsubscribeCommand(link)
+			}
+		}(link)
+	}
+
+	subscribers.Wait()
+	close(stop)
+	background.Wait()
+
+	if subscriptions := context.GetSubscriptions(); len(subscriptions) != 0 {
+		t.Errorf("%d subscriptions left", len(subscriptions))
+	}
+}
+
+// TestAccountConcurrency changes the settings of the chat while entries pass
+// through the outbox, run it with -race.
+func TestAccountConcurrency(t *testing.T) {
+	context := newTestContext(t, 1002)
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		timezones := []string{"UTC", "Europe/Paris"}
+		for round := 0; ; round++ {
+			if err := context.SetTimezone(timezones[round%len(timezones)]); err != nil {
+				t.Error(err)
+				return
+			}
+
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+
+	for round := 0; round < 200; round++ {
+		entry := &OutboxEntry{
+			Id:       fmt.Sprintf("account-%d", round),
+			Message:  "message",
+			Sequence: int64(round),
+		}
+		if err := context.Enqueue([]*OutboxEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+		context.ack(entry)
+	}
+
+	close(stop)
+	background.Wait()
+
+	if timezone := context.Account().Timezone; timezone != "UTC" && timezone != "Europe/Paris" {
+		t.Errorf("timezone %q", timezone)
+	}
+}
+
+// TestMigrateContext upgrades a restricted group and checks the supergroup
+// keeps its settings.
+func TestMigrateContext(t *testing.T) {
+	source, err := NewContext(-1003, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.SetPermission(PermissionAllowlist, []int64{42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.SetTimezone("Europe/Paris"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateContext(-1003, -1001003); err != nil {
+		t.Fatal(err)
+	}
+	if FindContext(-1003) != nil {
+		t.Error("the group is left over")
+	}
+
+	target := FindContext(-1001003)
+	if target == nil {
+		t.Fatal("the supergroup is missing")
+	}
+	defer target.Stop()
+
+	permission, allowlist := target.GetPermission()
+	if permission != PermissionAllowlist || len(allowlist) != 1 || allowlist[0] != 42 {
+		t.Errorf("permission %d with %v", permission, allowlist)
+	}
+	if timezone := target.Account().Timezone; timezone != "Europe/Paris" {
+		t.Errorf("timezone %q", timezone)
+	}
+
+	stored, err := SharedStore().GetAccount(-1001003)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Permission != PermissionAllowlist {
+		t.Errorf("stored permission %d", stored.Permission)
+	}
+}
+
+// TestFailedEntries keeps the latest refused entries only, until they are retried.
+func TestFailedEntries(t *testing.T) {
+	context := newTestContext(t, 1004)
+
+	for round := 0; round < maxFailed+5; round++ {
+		entry := &OutboxEntry{
+			Id:       fmt.Sprintf("failed-%d", round),
+			Message:  "message",
+			Sequence: int64(round),
+		}
+		if err := context.Enqueue([]*OutboxEntry{entry}); err != nil {
+			t.Fatal(err)
+		}
+		context.reject(entry, errors.New("Bad Request: refused"))
+	}
+
+	failed := context.GetFailed()
+	if len(failed) != maxFailed || failed[0].Id != "failed-5" {
+		t.Fatalf("%d failed entries kept, the first is %s", len(failed), failed[0].Id)
+	}
+	stored, err := SharedStore().GetOutbox(context.Account())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != maxFailed {
+		t.Errorf("%d entries stored", len(stored))
+	}
+
+	if err := context.RetryFailed(); err != nil {
+		t.Fatal(err)
+	}
+	context.mutex.Lock()
+	outbox := len(context.outbox)
+	context.mutex.Unlock()
+	if len(context.GetFailed()) != 0 || outbox != maxFailed {
+		t.Errorf("%d entries back in the outbox", outbox)
+	}
+}
+
+// TestMembership keeps the outbox of a chat the bot left until it is back, and
+// ignores leaving chats it never served.
+func TestMembership(t *testing.T) {
+	context := newTestContext(t, 1006)
+	contextsMutex.Lock()
+	contexts[context.id] = context
+	contextsMutex.Unlock()
+	defer func() {
+		contextsMutex.Lock()
+		delete(contexts, context.id)
+		contextsMutex.Unlock()
+	}()
+
+	entry := &OutboxEntry{Id: "membership", Message: "message"}
+	if err := context.Enqueue([]*OutboxEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+
+	update := &ChatMemberUpdated{Chat: tgbotapi.Chat{ID: context.id, Type: "group"}}
+	update.NewChatMember.Status = "kicked"
+	if err := HandleMembership(update); err != nil {
+		t.Fatal(err)
+	}
+	if !context.Account().Inactive {
+		t.Error("the chat is still active")
+	}
+
+	update.NewChatMember.Status = "member"
+	if err := HandleMembership(update); err != nil {
+		t.Fatal(err)
+	}
+	context.mutex.Lock()
+	outbox := len(context.outbox)
+	context.mutex.Unlock()
+	if context.Account().Inactive || outbox != 1 {
+		t.Errorf("%d entries left in the outbox of the active chat", outbox)
+	}
+
+	update = &ChatMemberUpdated{Chat: tgbotapi.Chat{ID: 1007, Type: "group"}}
+	update.NewChatMember.Status = "left"
+	if err := HandleMembership(update); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := SharedStore().GetAccount(1007); err != nil || account != nil {
+		t.Errorf("an account was created for a chat the bot never served: %v", err)
+	}
+}
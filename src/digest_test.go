@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDigestNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		digest   Digest
+		now      time.Time
+		location *time.Location
+		next     time.Time
+	}{
+		{
+			name:     "default time later today",
+			now:      time.Date(2021, 5, 1, 6, 30, 0, 0, time.UTC),
+			location: time.UTC,
+			next:     time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "default time passed",
+			now:      time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			next:     time.Date(2021, 5, 2, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "earliest of the times",
+			digest:   Digest{Times: []string{"20:00", "12:30", "invalid"}},
+			now:      time.Date(2021, 5, 1, 9, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			next:     time.Date(2021, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "time in the chat timezone",
+			digest:   Digest{Times: []string{"08:00"}},
+			now:      time.Date(2021, 5, 1, 7, 0, 0, 0, time.UTC),
+			location: paris,
+			next:     time.Date(2021, 5, 2, 8, 0, 0, 0, paris),
+		},
+		{
+			name:     "interval",
+			digest:   Digest{Interval: 90},
+			now:      time.Date(2021, 5, 1, 2, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			next:     time.Date(2021, 5, 1, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "interval starting over at midnight",
+			digest:   Digest{Interval: 420},
+			now:      time.Date(2021, 5, 1, 22, 0, 0, 0, time.UTC),
+			location: time.UTC,
+			next:     time.Date(2021, 5, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := test.digest.Next(test.now, test.location)
+			if !next.Equal(test.next) {
+				t.Errorf("got %v, want %v", next, test.next)
+			}
+		})
+	}
+}
+
+// TestCompose sends up to the limit and keeps the other entries for the next digest.
+func TestCompose(t *testing.T) {
+	context := newTestContext(t, 1005)
+	if err := context.SetDigest(Digest{Enabled: true, Limit: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make([]*OutboxEntry, 0)
+	for index := 0; index < 5; index++ {
+		entries = append(entries, &OutboxEntry{
+			Id:             fmt.Sprintf("digest-entry-%d", index),
+			SubscriptionId: "subscription",
+			Message:        fmt.Sprintf("item %d", index),
+			Sequence:       int64(index),
+			Digest:         true,
+			ParseMode:      SharedFormatter().Mode(),
+		})
+	}
+	if err := context.Enqueue(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := context.Compose(); err != nil {
+		t.Fatal(err)
+	}
+
+	context.mutex.Lock()
+	outbox := append([]*OutboxEntry{}, context.outbox...)
+	digest := append([]*OutboxEntry{}, context.digest...)
+	context.mutex.Unlock()
+
+	if len(outbox) != 1 {
+		t.Fatalf("%d messages in the outbox", len(outbox))
+	}
+	for index, entry := range entries {
+		if included := strings.Contains(outbox[0].Message, entry.Message); included != (index < 3) {
+			t.Errorf("%s included: %v", entry.Message, included)
+		}
+	}
+	if len(digest) != 2 || digest[0].Id != "digest-entry-3" {
+		t.Fatalf("%d entries left for the next digest", len(digest))
+	}
+
+	stored, err := SharedStore().GetOutbox(context.Account())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("%d entries stored, want the digest and the 2 left", len(stored))
+	}
+
+	if err := context.Compose(); err != nil {
+		t.Fatal(err)
+	}
+	if _, left := context.GetDigest(); left != 0 {
+		t.Errorf("%d entries left after the second digest", left)
+	}
+}
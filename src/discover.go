@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	nethtml "golang.org/x/net/html"
+)
+
+// Feeds are looked for at these paths of the site when a page declares none.
+var feedPaths = []string{"/feed", "/rss", "/feed.xml", "/rss.xml", "/atom.xml", "/index.xml", "/feed.json"}
+
+var feedTypes = []string{"application/rss+xml", "application/atom+xml", "application/feed+json"}
+
+// maxFeedChoices caps the feeds offered for a page.
+const maxFeedChoices = 8
+
+// FeedLink is a feed found for a web page.
+type FeedLink struct {
+	URL   string
+	Title string
+}
+
+// FeedChoiceError is returned for a web page with several feeds, one of them
+// has to be picked.
+type FeedChoiceError struct {
+	URL   string
+	Feeds []*FeedLink
+}
+
+func (err *FeedChoiceError) Error() string {
+	return fmt.Sprintf("%s has %d feeds", err.URL, len(err.Feeds))
+}
+
+func isHTML(contentType string, body []byte) bool {
+	if strings.Contains(contentType, "html") {
+		return true
+	}
+
+	head := body
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	head = bytes.ToLower(head)
+
+	return bytes.Contains(head, []byte("<!doctype html")) || bytes.Contains(head, []byte("<html"))
+}
+
+// Discover returns the feeds the page at address declares, or else those found
+// at the usual paths of its site.
+func Discover(address string, body []byte) []*FeedLink {
+	base, err := url.Parse(address)
+	if err != nil {
+		return nil
+	}
+
+	feeds := declaredFeeds(base, body)
+	if len(feeds) == 0 {
+		feeds = probeFeeds(base)
+	}
+	if len(feeds) > maxFeedChoices {
+		feeds = feeds[:maxFeedChoices]
+	}
+
+	return feeds
+}
+
+// declaredFeeds reads the <link rel="alternate"> feeds of the page.
+func declaredFeeds(base *url.URL, body []byte) []*FeedLink {
+	document, err := nethtml.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	feeds := make([]*FeedLink, 0)
+	seen := make(map[string]bool)
+
+	var walk func(node *nethtml.Node)
+	walk = func(node *nethtml.Node) {
+		if node.Type == nethtml.ElementNode {
+			switch node.Data {
+			case "base":
+				if href, err := base.Parse(attribute(node, "href")); err == nil {
+					base = href
+				}
+			case "link":
+				rel := strings.Fields(strings.ToLower(attribute(node, "rel")))
+				kind := strings.ToLower(strings.TrimSpace(attribute(node, "type")))
+				if containsString(rel, "alternate") && containsString(feedTypes, kind) {
+					href, err := base.Parse(strings.TrimSpace(attribute(node, "href")))
+					if err == nil && (href.Scheme == "http" || href.Scheme == "https") && !seen[href.String()] {
+						seen[href.String()] = true
+
+						title := strings.TrimSpace(attribute(node, "title"))
+						if len(title) == 0 {
+							title = href.String()
+						}
+						feeds = append(feeds, &FeedLink{URL: href.String(), Title: title})
+					}
+				}
+			case "body":
+				// Feeds are declared in the head.
+				return
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(document)
+
+	return feeds
+}
+
+// probeFeeds fetches the usual feed paths of the site, keeping the order of
+// feedPaths and the first path of each feed.
+func probeFeeds(base *url.URL) []*FeedLink {
+	found := make([]*FeedLink, len(feedPaths))
+	ids := make([]string, len(feedPaths))
+
+	var wait sync.WaitGroup
+	for index, path := range feedPaths {
+		wait.Add(1)
+		go func(index int, path string) {
+			defer wait.Done()
+
+			address := (&url.URL{Scheme: base.Scheme, Host: base.Host, Path: path}).String()
+			feed, err := fetch(address, nil)
+			if err != nil {
+				return
+			}
+
+			title := feed.Title
+			if len(title) == 0 {
+				title = address
+			}
+			found[index] = &FeedLink{URL: address, Title: title}
+			ids[index] = fmt.Sprintf("%x", md5.Sum([]byte(feed.Link)))
+		}(index, path)
+	}
+	wait.Wait()
+
+	feeds := make([]*FeedLink, 0)
+	seen := make(map[string]bool)
+	for index, feed := range found {
+		if feed == nil || seen[ids[index]] {
+			continue
+		}
+		seen[ids[index]] = true
+		feeds = append(feeds, feed)
+	}
+
+	return feeds
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// wordPressPage is the head of a WordPress page, its REST API is declared as
+// an alternate JSON document.
+const wordPressPage = `<!DOCTYPE html>
+<html><head>
+<title>A blog</title>
+%s
+<link rel="alternate" type="application/json" href="/wp-json/wp/v2/pages/2">
+<link rel="https://api.w.org/" href="/wp-json/">
+</head><body><a href="/feed">Not declared here</a></body></html>`
+
+func TestDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>A blog</title><link>https://example.com</link></channel></rss>`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name  string
+		links string
+		feeds []string
+	}{
+		{
+			name: "declared feeds",
+			links: `<link rel="alternate" type="application/rss+xml" title="A blog" href="/feed">
+<link rel="alternate" type="application/rss+xml" title="Comments" href="/comments/feed">`,
+			feeds: []string{server.URL + "/feed", server.URL + "/comments/feed"},
+		},
+		{
+			name:  "only the rest api",
+			feeds: []string{server.URL + "/feed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feeds := Discover(server.URL+"/about", []byte(fmt.Sprintf(wordPressPage, test.links)))
+
+			urls := make([]string, 0, len(feeds))
+			for _, feed := range feeds {
+				urls = append(urls, feed.URL)
+			}
+			if fmt.Sprint(urls) != fmt.Sprint(test.feeds) {
+				t.Errorf("got %v, want %v", urls, test.feeds)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+)
+
+// NetworkError is returned when the server of a feed could not be reached or
+// the response was cut short.
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (err *NetworkError) Error() string {
+	return fmt.Sprintf("fetching %s: %v", err.URL, err.Err)
+}
+
+func (err *NetworkError) Unwrap() error {
+	return err.Err
+}
+
+// StatusError is returned when the server of a feed answers with an error status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("fetching %s: %s", err.URL, err.Status)
+}
+
+// NotFeedError is returned when the document fetched is no feed, the body is
+// kept when it is a web page whose feeds may be discovered.
+type NotFeedError struct {
+	URL  string
+	body []byte
+}
+
+func (err *NotFeedError) Error() string {
+	return fmt.Sprintf("%s is not a feed", err.URL)
+}
+
+// TooLargeError is returned when the document fetched exceeds Limit bytes.
+type TooLargeError struct {
+	URL   string
+	Limit int
+}
+
+func (err *TooLargeError) Error() string {
+	return fmt.Sprintf("fetching %s: larger than %d bytes", err.URL, err.Limit)
+}
+
+// ParseError is returned when the feed is malformed.
+type ParseError struct {
+	URL string
+	Err error
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("parsing %s: %v", err.URL, err.Err)
+}
+
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// InvalidURLError is returned when the address of a feed is not a URL.
+type InvalidURLError struct {
+	URL string
+}
+
+func (err *InvalidURLError) Error() string {
+	return fmt.Sprintf("%s is not a valid url", err.URL)
+}
+
+// DuplicateError is returned when the chat is already subscribed to the feed.
+type DuplicateError struct {
+	Subscription *Subscription
+}
+
+func (err *DuplicateError) Error() string {
+	return fmt.Sprintf("already subscribed to %s", err.Subscription.Link)
+}
+
+// LimitError is returned when the chat has as many subscriptions as allowed.
+type LimitError struct {
+	Limit int
+}
+
+func (err *LimitError) Error() string {
+	return fmt.Sprintf("subscription limit of %d reached", err.Limit)
+}
+
+// StorageError is returned when the store failed to read or write, Op tells
+// what was being done.
+type StorageError struct {
+	Op  string
+	Err error
+}
+
+func (err *StorageError) Error() string {
+	return fmt.Sprintf("%s: %v", err.Op, err.Err)
+}
+
+func (err *StorageError) Unwrap() error {
+	return err.Err
+}
+
+// storageError wraps the error of a store operation, it is nil when err is.
+func storageError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StorageError{Op: op, Err: err}
+}
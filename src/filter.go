@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var filterFields = []string{"title", "description", "categories", "author"}
+
+func NewFilter(exclude bool, pattern string, regex bool, caseSensitive bool, fields []string) (*Filter, error) {
+	for _, field := range fields {
+		if !containsString(filterFields, field) {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+	}
+
+	filter := &Filter{
+		Exclude:       exclude,
+		Pattern:       pattern,
+		Regex:         regex,
+		CaseSensitive: caseSensitive,
+		Fields:        fields,
+	}
+
+	if regex {
+		if _, err := filter.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
+
+// Admit reports whether the item passes the filters: it has to match one of the
+// include rules, if there are any, and none of the exclude rules.
+func Admit(filters []*Filter, item *Item) bool {
+	included, includes := false, 0
+	for _, filter := range filters {
+		matched := filter.Match(item)
+		if filter.Exclude && matched {
+			return false
+		}
+		if !filter.Exclude {
+			includes++
+			included = included || matched
+		}
+	}
+
+	return includes == 0 || included
+}
+
+func (filter *Filter) Match(item *Item) bool {
+	fields := filter.Fields
+	if len(fields) == 0 {
+		fields = filterFields
+	}
+
+	var texts []string
+	for _, field := range fields {
+		switch field {
+		case "title":
+			texts = append(texts, item.title)
+		case "description":
+			texts = append(texts, item.description)
+		case "categories":
+			texts = append(texts, item.categories...)
+		case "author":
+			texts = append(texts, item.authors...)
+		}
+	}
+
+	if filter.Regex {
+		re, err := filter.compile()
+		if err != nil {
+			return false
+		}
+		for _, text := range texts {
+			if re.MatchString(text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	pattern := filter.Pattern
+	if !filter.CaseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	for _, text := range texts {
+		if !filter.CaseSensitive {
+			text = strings.ToLower(text)
+		}
+		if strings.Contains(text, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func (filter *Filter) compile() (*regexp.Regexp, error) {
+	if filter.compiled != nil {
+		return filter.compiled, nil
+	}
+
+	pattern := filter.Pattern
+	if !filter.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	filter.compiled = re
+
+	return re, nil
+}
+
+func (filter *Filter) String() string {
+	kind := "include"
+	if filter.Exclude {
+		kind = "exclude"
+	}
+
+	var options []string
+	if filter.Regex {
+		options = append(options, "regex")
+	}
+	if filter.CaseSensitive {
+		options = append(options, "case sensitive")
+	}
+	if len(filter.Fields) > 0 {
+		options = append(options, "in "+strings.Join(filter.Fields, ", "))
+	}
+
+	if len(options) == 0 {
+		return fmt.Sprintf("%s %q", kind, filter.Pattern)
+	}
+	return fmt.Sprintf("%s %q (%s)", kind, filter.Pattern, strings.Join(options, ", "))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAdmit(t *testing.T) {
+	item := &Item{
+		title:       "Go 1.17 is released",
+		description: "The latest release of the Go language.",
+		categories:  []string{"Release"},
+		authors:     []string{"The Go Team"},
+	}
+
+	filter := func(exclude bool, pattern string, regex bool, caseSensitive bool, fields ...string) *Filter {
+		filter, err := NewFilter(exclude, pattern, regex, caseSensitive, fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filter
+	}
+
+	tests := []struct {
+		name    string
+		filters []*Filter
+		admit   bool
+	}{
+		{"no filters", nil, true},
+		{"include matching", []*Filter{filter(false, "go", false, false)}, true},
+		{"include not matching", []*Filter{filter(false, "rust", false, false)}, false},
+		{"one of the includes", []*Filter{filter(false, "rust", false, false), filter(false, "release", false, false)}, true},
+		{"exclude wins", []*Filter{filter(false, "go", false, false), filter(true, "released", false, false)}, false},
+		{"exclude not matching", []*Filter{filter(true, "beta", false, false)}, true},
+		{"case sensitive", []*Filter{filter(false, "GO", false, true)}, false},
+		{"regex", []*Filter{filter(false, `go 1\.\d+`, true, false)}, true},
+		{"fields", []*Filter{filter(false, "language", false, false, "title")}, false},
+		{"categories", []*Filter{filter(true, "release", false, false, "categories")}, false},
+		{"author", []*Filter{filter(false, "go team", false, false, "author")}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if admit := Admit(test.filters, item); admit != test.admit {
+				t.Errorf("got %v, want %v", admit, test.admit)
+			}
+		})
+	}
+
+	if _, err := NewFilter(false, "x", false, false, []string{"body"}); err == nil {
+		t.Error("no error for an unknown field")
+	}
+	if _, err := NewFilter(false, "(", true, false, nil); err == nil {
+		t.Error("no error for an invalid regex")
+	}
+}
@@ -52,3 +52,11 @@ func (fb Firebase) SaveAccount(account *Account) error {
 
 	return err
 }
+
+func (fb Firebase) DeleteAccount(account *Account) error {
+	id := strconv.FormatInt(account.Id, 10)
+
+	_, err := fb.firestore.Collection("accounts").Doc(id).Delete(fb.ctx)
+
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+func (fb Firebase) GetOutbox(account *Account) ([]*OutboxEntry, error) {
+	id := strconv.FormatInt(account.Id, 10)
+
+	entries := make([]*OutboxEntry, 0)
+
+	iter := fb.firestore.Collection("assets").Doc(id).Collection("outbox").OrderBy("sequence", firestore.Asc).Documents(fb.ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var entry OutboxEntry
+		err = doc.DataTo(&entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+func (fb Firebase) AddOutboxEntries(account *Account, entries []*OutboxEntry) error {
+	id := strconv.FormatInt(account.Id, 10)
+
+	// A batch holds at most 500 writes.
+	for start := 0; start < len(entries); start += 500 {
+		end := start + 500
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		batch := fb.firestore.Batch()
+		for _, entry := range entries[start:end] {
+			batch.Set(fb.firestore.Collection("assets").Doc(id).Collection("outbox").Doc(entry.Id), entry)
+		}
+
+		_, err := batch.Commit(fb.ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (fb Firebase) DeleteOutboxEntry(account *Account, entry *OutboxEntry) error {
+	id := strconv.FormatInt(account.Id, 10)
+
+	_, err := fb.firestore.Collection("assets").Doc(id).Collection("outbox").Doc(entry.Id).Delete(fb.ctx)
+
+	return err
+}
@@ -40,3 +40,34 @@ func (fb Firebase) GetTopSubscriptions(num int) ([]*SubscriptionStatistic, error
 
 	return statistics, nil
 }
+
+func (fb Firebase) GetStatistics() ([]*SubscriptionStatistic, error) {
+	statistics := make([]*SubscriptionStatistic, 0)
+
+	iter := fb.firestore.Collection("statistics").Doc("subscriptions").Collection("subscribe_count").Documents(fb.ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var statistic SubscriptionStatistic
+		err = doc.DataTo(&statistic)
+		if err != nil {
+			return nil, err
+		}
+
+		statistics = append(statistics, &statistic)
+	}
+
+	return statistics, nil
+}
+
+func (fb Firebase) SetStatistic(statistic *SubscriptionStatistic) error {
+	_, err := fb.firestore.Collection("statistics").Doc("subscriptions").Collection("subscribe_count").Doc(statistic.Subscription.Id).Set(fb.ctx, statistic)
+
+	return err
+}
@@ -78,6 +78,14 @@ func (fb Firebase) AddSubscription(account *Account, subscription *Subscription)
 	return err
 }
 
+func (fb Firebase) SaveSubscription(account *Account, subscription *Subscription) error {
+	id := strconv.FormatInt(account.Id, 10)
+
+	_, err := fb.firestore.Collection("assets").Doc(id).Collection("subscriptions").Doc(subscription.Id).Set(fb.ctx, subscription)
+
+	return err
+}
+
 func (fb Firebase) DeleteSubscription(account *Account, subscription *Subscription) error {
 	id := strconv.FormatInt(account.Id, 10)
 
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	nethtml "golang.org/x/net/html"
+)
+
+// Telegram refuses messages longer than this many characters, counted in
+// UTF-16 code units.
+const maxMessageLength = 4096
+
+// Formatter writes the markup of a Telegram parse mode. Everything but Escape
+// and Plain takes text that is already escaped.
+type Formatter interface {
+	Mode() string
+	Escape(text string) string
+	Bold(text string) string
+	Italic(text string) string
+	Underline(text string) string
+	Strike(text string) string
+	Code(text string) string
+	Pre(text string) string
+	Link(text string, url string) string
+	// Plain strips the markup of a message, links are kept next to their text.
+	Plain(message string) string
+	// Tokens cuts a message into the pieces Split keeps whole.
+	Tokens(message string) []Token
+}
+
+// Token is a piece of markup Split never cuts. A token opening an entity
+// carries the markup closing it, the token ending the entity is marked closing.
+type Token struct {
+	Text    string
+	Closer  string
+	Closing bool
+}
+
+// Markup is text already written in the markup of the formatter, format keeps
+// it as it is.
+type Markup string
+
+func SharedFormatter() Formatter {
+	if parseMode == "markdownv2" {
+		return MarkdownV2Formatter{}
+	}
+	return HTMLFormatter{}
+}
+
+// FormatterFor returns the formatter of a parse mode, messages stored without
+// one were written in legacy Markdown.
+func FormatterFor(mode string) Formatter {
+	switch mode {
+	case HTMLFormatter{}.Mode():
+		return HTMLFormatter{}
+	case MarkdownV2Formatter{}.Mode():
+		return MarkdownV2Formatter{}
+	default:
+		return MarkdownFormatter{}
+	}
+}
+
+// format escapes the formatted text except for the Markup arguments.
+func format(layout string, args ...interface{}) string {
+	formatter := SharedFormatter()
+
+	// Markup is swapped for placeholders the escaping leaves alone.
+	values := make([]interface{}, len(args))
+	markups := make([]string, 0)
+	for index, arg := range args {
+		if markup, ok := arg.(Markup); ok {
+			values[index] = fmt.Sprintf("\x00%d\x00", len(markups))
+			markups = append(markups, string(markup))
+		} else {
+			values[index] = arg
+		}
+	}
+
+	text := formatter.Escape(fmt.Sprintf(layout, values...))
+	for index, markup := range markups {
+		text = strings.Replace(text, fmt.Sprintf("\x00%d\x00", index), markup, 1)
+	}
+
+	return text
+}
+
+func link(text string, url string) Markup {
+	formatter := SharedFormatter()
+	return Markup(formatter.Link(formatter.Escape(text), formatter.Escape(url)))
+}
+
+func bold(text string) Markup {
+	formatter := SharedFormatter()
+	return Markup(formatter.Bold(formatter.Escape(text)))
+}
+
+func code(text string) Markup {
+	formatter := SharedFormatter()
+	return Markup(formatter.Code(formatter.Escape(text)))
+}
+
+type HTMLFormatter struct{}
+
+func (HTMLFormatter) Mode() string {
+	return "HTML"
+}
+
+func (HTMLFormatter) Escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(text)
+}
+
+func (HTMLFormatter) Bold(text string) string {
+	return "<b>" + text + "</b>"
+}
+
+func (HTMLFormatter) Italic(text string) string {
+	return "<i>" + text + "</i>"
+}
+
+func (HTMLFormatter) Underline(text string) string {
+	return "<u>" + text + "</u>"
+}
+
+func (HTMLFormatter) Strike(text string) string {
+	return "<s>" + text + "</s>"
+}
+
+func (HTMLFormatter) Code(text string) string {
+	return "<code>" + text + "</code>"
+}
+
+func (HTMLFormatter) Pre(text string) string {
+	return "<pre>" + text + "</pre>"
+}
+
+func (HTMLFormatter) Link(text string, url string) string {
+	return `<a href="` + url + `">` + text + "</a>"
+}
+
+func (HTMLFormatter) Plain(message string) string {
+	nodes, err := nethtml.ParseFragment(strings.NewReader(message), nil)
+	if err != nil {
+		return html.UnescapeString(message)
+	}
+
+	var builder strings.Builder
+	var walk func(node *nethtml.Node)
+	walk = func(node *nethtml.Node) {
+		if node.Type == nethtml.TextNode {
+			builder.WriteString(node.Data)
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if href := attribute(node, "href"); node.Type == nethtml.ElementNode && node.Data == "a" && len(href) > 0 {
+			builder.WriteString(" (" + href + ")")
+		}
+	}
+	for _, node := range nodes {
+		walk(node)
+	}
+
+	return builder.String()
+}
+
+// Tokens keeps tags and character references whole, a tag other than a
+// closing one opens an entity.
+func (HTMLFormatter) Tokens(message string) []Token {
+	tokens := make([]Token, 0)
+	for len(message) > 0 {
+		size := 0
+		switch message[0] {
+		case '<':
+			size = strings.IndexByte(message, '>') + 1
+		case '&':
+			if end := strings.IndexByte(message, ';'); end > 0 && end < 10 {
+				size = end + 1
+			}
+		}
+		if size == 0 {
+			_, size = utf8.DecodeRuneInString(message)
+			tokens = append(tokens, Token{Text: message[:size]})
+			message = message[size:]
+			continue
+		}
+
+		token := Token{Text: message[:size]}
+		if strings.HasPrefix(token.Text, "</") {
+			token.Closing = true
+		} else if message[0] == '<' {
+			name := strings.FieldsFunc(token.Text[1:size-1], func(r rune) bool {
+				return r == ' ' || r == '/'
+			})
+			if len(name) > 0 {
+				token.Closer = "</" + name[0] + ">"
+			}
+		}
+		tokens = append(tokens, token)
+		message = message[size:]
+	}
+
+	return tokens
+}
+
+type MarkdownV2Formatter struct{}
+
+var (
+	markdownV2Escaper = strings.NewReplacer(
+		`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+		">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+	)
+	markdownV2Link = regexp.MustCompile(`\[((?:\\.|[^\\\]])*)\]\(((?:\\.|[^\\)])*)\)`)
+	// markdownLinkToken matches a link at the start of the text.
+	markdownLinkToken = regexp.MustCompile(`^` + markdownV2Link.String())
+)
+
+func (MarkdownV2Formatter) Mode() string {
+	return "MarkdownV2"
+}
+
+func (MarkdownV2Formatter) Escape(text string) string {
+	return markdownV2Escaper.Replace(text)
+}
+
+func (MarkdownV2Formatter) Bold(text string) string {
+	return "*" + text + "*"
+}
+
+// Italic ends with a carriage return, Telegram ignores it but it keeps a
+// following underscore from turning the end into an underline.
+func (MarkdownV2Formatter) Italic(text string) string {
+	return "_" + text + "_\r"
+}
+
+func (MarkdownV2Formatter) Underline(text string) string {
+	return "__" + text + "__"
+}
+
+func (MarkdownV2Formatter) Strike(text string) string {
+	return "~" + text + "~"
+}
+
+func (MarkdownV2Formatter) Code(text string) string {
+	return "`" + text + "`"
+}
+
+func (MarkdownV2Formatter) Pre(text string) string {
+	return "```\n" + text + "\n```"
+}
+
+func (MarkdownV2Formatter) Link(text string, url string) string {
+	return "[" + text + "](" + url + ")"
+}
+
+func (MarkdownV2Formatter) Plain(message string) string {
+	message = markdownV2Link.ReplaceAllString(message, "$1 ($2)")
+
+	var builder strings.Builder
+	escaped := false
+	for _, r := range message {
+		switch {
+		case escaped:
+			builder.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case strings.ContainsRune("*_~`|\r", r):
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+func (MarkdownV2Formatter) Tokens(message string) []Token {
+	return markdownTokens(message, []string{"```", "`", "||", "__", "_", "*", "~"})
+}
+
+// MarkdownFormatter writes the legacy Markdown of messages stored before the
+// parse mode was recorded, it has no underline nor strikethrough.
+type MarkdownFormatter struct{}
+
+var markdownEscaper = strings.NewReplacer("_", `\_`, "*", `\*`, "[", `\[`, "`", "\\`")
+
+func (MarkdownFormatter) Mode() string {
+	return "Markdown"
+}
+
+func (MarkdownFormatter) Escape(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func (MarkdownFormatter) Bold(text string) string {
+	return "*" + text + "*"
+}
+
+func (MarkdownFormatter) Italic(text string) string {
+	return "_" + text + "_"
+}
+
+func (MarkdownFormatter) Underline(text string) string {
+	return text
+}
+
+func (MarkdownFormatter) Strike(text string) string {
+	return text
+}
+
+func (MarkdownFormatter) Code(text string) string {
+	return "`" + text + "`"
+}
+
+func (MarkdownFormatter) Pre(text string) string {
+	return "```\n" + text + "\n```"
+}
+
+func (MarkdownFormatter) Link(text string, url string) string {
+	return "[" + text + "](" + url + ")"
+}
+
+func (MarkdownFormatter) Plain(message string) string {
+	return MarkdownV2Formatter{}.Plain(message)
+}
+
+func (MarkdownFormatter) Tokens(message string) []Token {
+	return markdownTokens(message, []string{"```", "`", "_", "*"})
+}
+
+// markdownTokens keeps escapes, links and delimiters whole. The delimiters are
+// tried longest first, the one of the innermost entity closes it. Inside code
+// only its own delimiter counts.
+func markdownTokens(message string, delimiters []string) []Token {
+	tokens := make([]Token, 0)
+	open := make([]string, 0)
+	for len(message) > 0 {
+		inner := ""
+		if len(open) > 0 {
+			inner = open[len(open)-1]
+		}
+		code := strings.HasPrefix(inner, "`")
+
+		var token Token
+		switch {
+		case message[0] == '\\' && len(message) > 1:
+			_, size := utf8.DecodeRuneInString(message[1:])
+			token.Text = message[:1+size]
+		case len(inner) > 0 && strings.HasPrefix(message, inner):
+			token = Token{Text: inner, Closing: true}
+			open = open[:len(open)-1]
+		case !code && message[0] == '[' && markdownLinkToken.MatchString(message):
+			token.Text = markdownLinkToken.FindString(message)
+		case !code:
+			for _, delimiter := range delimiters {
+				if strings.HasPrefix(message, delimiter) {
+					token = Token{Text: delimiter, Closer: delimiter}
+					// A block keeps its line break so the text isn't taken for a language.
+					if delimiter == "```" && strings.HasPrefix(message[3:], "\n") {
+						token.Text += "\n"
+					}
+					open = append(open, delimiter)
+					break
+				}
+			}
+		}
+		if len(token.Text) == 0 {
+			_, size := utf8.DecodeRuneInString(message)
+			token.Text = message[:size]
+		}
+
+		tokens = append(tokens, token)
+		message = message[len(token.Text):]
+	}
+
+	return tokens
+}
+
+// Sanitize converts the HTML of a feed into the markup Telegram supports,
+// everything else is reduced to its text.
+func Sanitize(source string) string {
+	nodes, err := nethtml.ParseFragment(strings.NewReader(source), nil)
+	if err != nil {
+		return SharedFormatter().Escape(plainText(source))
+	}
+
+	var builder strings.Builder
+	for _, node := range nodes {
+		builder.WriteString(sanitize(SharedFormatter(), node, false))
+	}
+
+	return strings.TrimSpace(blankLines.ReplaceAllString(builder.String(), "\n\n"))
+}
+
+var (
+	blankLines = regexp.MustCompile(`\n\s*\n\s*`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+// sanitize converts the node, whitespace is kept as it is inside preformatted text.
+func sanitize(formatter Formatter, node *nethtml.Node, preformatted bool) string {
+	if node.Type == nethtml.TextNode {
+		if preformatted {
+			return formatter.Escape(node.Data)
+		}
+		return formatter.Escape(whitespace.ReplaceAllString(node.Data, " "))
+	}
+	if node.Type != nethtml.ElementNode && node.Type != nethtml.DocumentNode {
+		return ""
+	}
+
+	var builder strings.Builder
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		builder.WriteString(sanitize(formatter, child, preformatted || node.Data == "pre"))
+	}
+	text := builder.String()
+
+	switch node.Data {
+	case "script", "style", "img", "iframe", "video", "audio", "object", "svg", "head":
+		return ""
+	case "b", "strong":
+		return wrap(formatter.Bold, text)
+	case "i", "em":
+		return wrap(formatter.Italic, text)
+	case "u", "ins":
+		return wrap(formatter.Underline, text)
+	case "s", "strike", "del":
+		return wrap(formatter.Strike, text)
+	case "code":
+		if preformatted {
+			return text
+		}
+		return wrap(formatter.Code, text)
+	case "pre":
+		return "\n" + wrap(formatter.Pre, text) + "\n"
+	case "a":
+		href := attribute(node, "href")
+		if strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://") || strings.HasPrefix(href, "mailto:") {
+			if len(strings.TrimSpace(text)) == 0 {
+				text = formatter.Escape(href)
+			}
+			return formatter.Link(text, formatter.Escape(href))
+		}
+		return text
+	case "br":
+		return "\n"
+	case "li":
+		return "\n• " + strings.TrimSpace(text)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		return "\n\n" + wrap(formatter.Bold, strings.TrimSpace(text)) + "\n\n"
+	case "p", "div", "ul", "ol", "blockquote", "section", "article", "figure", "table", "tr":
+		return "\n\n" + text + "\n\n"
+	default:
+		return text
+	}
+}
+
+// wrap leaves out entities without text, Telegram rejects them.
+func wrap(entity func(string) string, text string) string {
+	if len(strings.TrimSpace(text)) == 0 {
+		return text
+	}
+	return entity(text)
+}
+
+func attribute(node *nethtml.Node, key string) string {
+	for _, attr := range node.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Split cuts the message into parts Telegram accepts, at line breaks where
+// possible. Tags, escapes and links are never cut, the entities open at a cut
+// are closed and opened again in the next part.
+func Split(formatter Formatter, message string, limit int) []string {
+	if textLength(message) <= limit {
+		return []string{message}
+	}
+
+	splitter := &splitter{limit: limit}
+	line := make([]Token, 0)
+	for _, token := range formatter.Tokens(message) {
+		line = append(line, token)
+		if token.Text == "\n" {
+			splitter.add(line)
+			line = make([]Token, 0)
+		}
+	}
+	splitter.add(line)
+	splitter.cut()
+
+	return splitter.parts
+}
+
+// textLength counts the text in UTF-16 code units like Telegram, characters
+// beyond the Basic Multilingual Plane such as emoji take two.
+func textLength(text string) int {
+	length := 0
+	for _, r := range text {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
+
+type splitter struct {
+	limit   int
+	parts   []string
+	current strings.Builder
+	length  int
+	// open are the tokens opening the entities not closed yet.
+	open []Token
+	// text tells the current part has more than the reopened entities.
+	text bool
+}
+
+// add appends a line to the current part, or starts a new one when it doesn't
+// fit. A line too long for any part is cut between tokens.
+func (splitter *splitter) add(line []Token) {
+	if !splitter.fits(line) {
+		splitter.cut()
+	}
+	if splitter.fits(line) {
+		for _, token := range line {
+			splitter.push(token)
+		}
+		return
+	}
+
+	for _, token := range line {
+		if !splitter.fits([]Token{token}) {
+			splitter.cut()
+		}
+		splitter.push(token)
+	}
+}
+
+// fits tells whether the tokens and the closers of the entities left open
+// after them fit into the current part.
+func (splitter *splitter) fits(tokens []Token) bool {
+	length := splitter.length
+	open := append([]Token(nil), splitter.open...)
+	for _, token := range tokens {
+		length += textLength(token.Text)
+		open = nest(open, token)
+	}
+	for _, token := range open {
+		length += textLength(token.Closer)
+	}
+
+	return length <= splitter.limit
+}
+
+func (splitter *splitter) push(token Token) {
+	splitter.current.WriteString(token.Text)
+	splitter.length += textLength(token.Text)
+	splitter.open = nest(splitter.open, token)
+	splitter.text = true
+}
+
+// cut ends the current part with the closers of the open entities and opens
+// them again at the start of the next one.
+func (splitter *splitter) cut() {
+	if !splitter.text {
+		return
+	}
+
+	for index := len(splitter.open) - 1; index >= 0; index-- {
+		splitter.current.WriteString(splitter.open[index].Closer)
+	}
+	if part := strings.TrimSpace(splitter.current.String()); len(part) > 0 {
+		splitter.parts = append(splitter.parts, part)
+	}
+
+	splitter.current.Reset()
+	splitter.length = 0
+	splitter.text = false
+	for _, token := range splitter.open {
+		splitter.current.WriteString(token.Text)
+		splitter.length += textLength(token.Text)
+	}
+}
+
+// nest returns the entities open after the token.
+func nest(open []Token, token Token) []Token {
+	if token.Closing && len(open) > 0 {
+		return open[:len(open)-1]
+	}
+	if len(token.Closer) > 0 {
+		return append(open, token)
+	}
+	return open
+}
+
+// isParseError reports whether Telegram refused the markup of the message.
+func isParseError(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	if !ok {
+		return false
+	}
+
+	return strings.Contains(apiErr.Message, "can't parse entities")
+}
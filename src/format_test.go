@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatter Formatter
+		message   string
+		limit     int
+		parts     []string
+	}{
+		{
+			name:      "html entity across lines",
+			formatter: HTMLFormatter{},
+			message:   "<b>first line\nsecond line</b>",
+			limit:     20,
+			parts:     []string{"<b>first line\n</b>", "<b>second line</b>"},
+		},
+		{
+			name:      "html tags and references",
+			formatter: HTMLFormatter{},
+			message:   `<a href="https://example.com">a&amp;b</a> tail`,
+			limit:     40,
+			parts:     []string{`<a href="https://example.com">a&amp;</a>`, `<a href="https://example.com">b</a> tail`},
+		},
+		{
+			name:      "markdownv2 escapes and entities",
+			formatter: MarkdownV2Formatter{},
+			message:   "*bold\\. text*\n__under_italic_\r__",
+			limit:     18,
+			parts:     []string{"*bold\\. text*", "__under_italic_\r__"},
+		},
+		{
+			name:      "markdownv2 links",
+			formatter: MarkdownV2Formatter{},
+			message:   "more text [a title](https://example.com)",
+			limit:     32,
+			parts:     []string{"more text", "[a title](https://example.com)"},
+		},
+		{
+			name:      "emoji",
+			formatter: HTMLFormatter{},
+			message:   "<b>" + strings.Repeat("😀", 6) + "</b>",
+			limit:     14,
+			parts:     []string{"<b>😀😀😀</b>", "<b>😀😀😀</b>"},
+		},
+		{
+			name:      "mostly emoji",
+			formatter: MarkdownV2Formatter{},
+			message:   "News " + strings.Repeat("😀", 3000),
+			limit:     maxMessageLength,
+			parts:     []string{"News " + strings.Repeat("😀", 2045), strings.Repeat("😀", 955)},
+		},
+		{
+			name:      "markdownv2 pre",
+			formatter: MarkdownV2Formatter{},
+			message:   "```\nfirst\nsecond\n```",
+			limit:     14,
+			parts:     []string{"```\nfirst\n```", "```\nsecond\n```"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := Split(test.formatter, test.message, test.limit)
+			if strings.Join(parts, "|") != strings.Join(test.parts, "|") {
+				t.Errorf("got %q, want %q", parts, test.parts)
+			}
+			for _, part := range parts {
+				if textLength(part) > test.limit {
+					t.Errorf("part %q is longer than %d", part, test.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestTemplateLiterals(t *testing.T) {
+	mode := parseMode
+	parseMode = "markdownv2"
+	defer func() { parseMode = mode }()
+
+	subscription := &Subscription{Title: "Feed", Link: "https://example.com"}
+	item := &Item{title: "Item_1", link: "https://example.com/1"}
+
+	message, err := RenderItem(`New (v2): {{link .Title .Link}} {{bold "read-me."}}`, subscription, item, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `New \(v2\): [Item\_1](https://example\.com/1) *read\-me\.*`
+	if message != want {
+		t.Errorf("got %q, want %q", message, want)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type TokenBucket struct {
+	capacity float64
+	tokens   float64
+	rate     float64
+	last     time.Time
+	until    time.Time
+	mutex    sync.Mutex
+}
+
+func NewTokenBucket(capacity int, per time.Duration) *TokenBucket {
+	return &TokenBucket{
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		rate:     float64(capacity) / per.Seconds(),
+		last:     time.Now(),
+	}
+}
+
+// Wait blocks until a token is available and takes it.
+func (bucket *TokenBucket) Wait() {
+	for {
+		delay := bucket.take()
+		if delay <= 0 {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Pause drains the bucket for the given duration, used when Telegram answers with retry_after.
+func (bucket *TokenBucket) Pause(duration time.Duration) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	until := time.Now().Add(duration)
+	if until.After(bucket.until) {
+		bucket.until = until
+	}
+	bucket.tokens = 0
+	bucket.last = bucket.until
+}
+
+func (bucket *TokenBucket) take() time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(bucket.until) {
+		return bucket.until.Sub(now)
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.capacity {
+		bucket.tokens = bucket.capacity
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		per      time.Duration
+		pause    time.Duration
+		burst    int
+		delay    time.Duration
+	}{
+		{
+			name:     "burst then wait",
+			capacity: 3,
+			per:      3 * time.Second,
+			burst:    3,
+			delay:    time.Second,
+		},
+		{
+			name:     "single token",
+			capacity: 1,
+			per:      2 * time.Second,
+			burst:    1,
+			delay:    2 * time.Second,
+		},
+		{
+			name:     "paused",
+			capacity: 3,
+			per:      time.Second,
+			pause:    5 * time.Second,
+			delay:    5 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bucket := NewTokenBucket(test.capacity, test.per)
+			if test.pause > 0 {
+				bucket.Pause(test.pause)
+			}
+
+			for index := 0; index < test.burst; index++ {
+				if delay := bucket.take(); delay != 0 {
+					t.Fatalf("token %d delayed by %v", index+1, delay)
+				}
+			}
+
+			delay := bucket.take()
+			if delay <= 0 || delay > test.delay || delay < test.delay-100*time.Millisecond {
+				t.Errorf("got %v, want about %v", delay, test.delay)
+			}
+		})
+	}
+}
+
+// TestTokenBucketWait blocks once the burst is spent.
+func TestTokenBucketWait(t *testing.T) {
+	bucket := NewTokenBucket(2, 100*time.Millisecond)
+
+	start := time.Now()
+	bucket.Wait()
+	bucket.Wait()
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("the burst took %v", elapsed)
+	}
+
+	bucket.Wait()
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("the third token came after %v, want about 50ms", elapsed)
+	}
+}
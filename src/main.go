@@ -5,12 +5,34 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
+	// Chat timezones must resolve without the system database.
+	_ "time/tzdata"
 
 	"github.com/alexflint/go-arg"
 )
 
 var args struct {
-	Token string `arg:"-t,--token" help:"telegram bot token"`
+	Token            string        `arg:"-t,--token" help:"telegram bot token"`
+	Mode             string        `arg:"-m,--mode" default:"polling" help:"how updates are received, polling or webhook"`
+	WebhookURL       string        `arg:"--webhook-url" help:"public URL Telegram posts updates to"`
+	WebhookSecret    string        `arg:"--webhook-secret" help:"secret token expected on webhook requests, random if empty"`
+	Listen           string        `arg:"--listen" default:":8443" help:"webhook listen address"`
+	TLSCert          string        `arg:"--tls-cert" help:"webhook TLS certificate file"`
+	TLSKey           string        `arg:"--tls-key" help:"webhook TLS key file"`
+	ParseMode        string        `arg:"--parse-mode" default:"html" help:"formatting of the messages sent, html or markdownv2"`
+	Storage          string        `arg:"-s,--storage" default:"firebase" help:"storage backend, firebase or sqlite"`
+	Database         string        `arg:"-d,--database" default:"./data/bot.db" help:"sqlite database path"`
+	Export           string        `arg:"--export" help:"export all data to a JSON archive and exit"`
+	Import           string        `arg:"--import" help:"import a JSON archive and exit"`
+	Migrate          string        `arg:"--migrate" help:"copy all data to another storage backend and exit"`
+	Workers          int           `arg:"--workers" default:"8" help:"number of feeds fetched concurrently"`
+	FetchTimeout     time.Duration `arg:"--fetch-timeout" default:"30s" help:"timeout of a single feed request"`
+	MinInterval      time.Duration `arg:"--min-interval" default:"1m" help:"shortest interval between two polls of a feed"`
+	MaxInterval      time.Duration `arg:"--max-interval" default:"6h" help:"longest interval between two polls of a feed"`
+	FailureThreshold int           `arg:"--failure-threshold" default:"8" help:"consecutive fetch failures before subscribers are notified"`
+	ShutdownTimeout  time.Duration `arg:"--shutdown-timeout" default:"30s" help:"how long to wait for in-flight deliveries on shutdown"`
+	MaxSubscriptions int           `arg:"--max-subscriptions" default:"0" help:"most subscriptions a chat may have, 0 for no limit"`
 }
 
 func launch() {
@@ -18,21 +40,105 @@ func launch() {
 
 	InitMonitor()
 
-	InitContents()
+	if err := InitContents(); err != nil {
+		log.Println(err)
+	}
+}
+
+// shutdown stops taking updates first, then lets the monitor and the outbox
+// finish their work in progress before the remaining feed caches are written out.
+func shutdown() {
+	SharedSession().Stop()
+	log.Println(`Session stopped`)
+
+	SharedMonitor().Stop()
+	log.Println(`Monitor stopped`)
+
+	StopContexts()
+	log.Println(`Contexts stopped`)
 }
 
 func main() {
 	arg.MustParse(&args)
 
 	token = args.Token
+	mode = args.Mode
+	webhookURL = args.WebhookURL
+	webhookSecret = args.WebhookSecret
+	listenAddress = args.Listen
+	tlsCert = args.TLSCert
+	tlsKey = args.TLSKey
+	parseMode = args.ParseMode
+	storage = args.Storage
+	database = args.Database
+	workers = args.Workers
+	fetchTimeout = args.FetchTimeout
+	minInterval = args.MinInterval
+	maxInterval = args.MaxInterval
+	failureThreshold = args.FailureThreshold
+	shutdownTimeout = args.ShutdownTimeout
+	maxSubscriptions = args.MaxSubscriptions
+
+	if handled, err := RunArchiveCommands(); err != nil {
+		log.Fatal(err)
+	} else if handled {
+		return
+	}
+
 	if len(token) == 0 {
 		log.Fatal("token not found")
 	}
 
+	if mode != "polling" && mode != "webhook" {
+		log.Fatalf("unknown mode %s", mode)
+	}
+
+	if parseMode != "html" && parseMode != "markdownv2" {
+		log.Fatalf("unknown parse mode %s", parseMode)
+	}
+
+	if mode == "webhook" && len(webhookURL) == 0 {
+		log.Fatal("webhook url not found")
+	}
+
+	if workers <= 0 {
+		log.Fatal("workers must be positive")
+	}
+
+	if minInterval <= 0 || maxInterval < minInterval {
+		log.Fatal("invalid polling intervals")
+	}
+
+	if failureThreshold < 1 {
+		log.Fatal("failure threshold must be at least 1")
+	}
+
+	if maxSubscriptions < 0 {
+		log.Fatal("max subscriptions must not be negative")
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
 
-	go launch()
+	launched := make(chan struct{})
+	go func() {
+		launch()
+		close(launched)
+	}()
 
 	<-sigs
+	log.Println(`Shutting down`)
+
+	done := make(chan struct{})
+	go func() {
+		<-launched
+		shutdown()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		log.Println(`Shutdown timed out`)
+	}
 }
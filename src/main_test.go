@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "bot")
+	if err != nil {
+		panic(err)
+	}
+
+	storage = "sqlite"
+	database = filepath.Join(dir, "bot.db")
+	parseMode = "html"
+	workers = 4
+	fetchTimeout = 5 * time.Second
+	minInterval = time.Millisecond
+	maxInterval = 5 * time.Millisecond
+	failureThreshold = 8
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newFeedServer serves a feed at /feed/<name> with a new item on every request,
+// /flaky/<name> fails every other request.
+func newFeedServer(t *testing.T) *httptest.Server {
+	var requests, flaky int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt64(&requests, 1)
+
+		name := filepath.Base(r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/flaky/") && atomic.AddInt64(&flaky, 1)%2 == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintf(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Feed %s</title><link>https://example.com/%s</link>`, name, name)
+		for item := count; item > count-3 && item > 0; item-- {
+			fmt.Fprintf(w, `<item><title>Item %d</title><link>https://example.com/%s/%d</link><guid>%s-%d</guid></item>`, item, name, item, name, item)
+		}
+		fmt.Fprint(w, `</channel></rss>`)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestContext creates a context without its delivery worker, entries stay
+// in the outbox.
+func newTestContext(t *testing.T, id int64) *Context {
+	account := &Account{Id: id}
+	if err := SharedStore().SaveAccount(account); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Context{
+		id:            id,
+		account:       account,
+		subscriptions: make(map[string]*Subscription),
+		caches:        make(map[string]map[string]interface{}),
+		dirty:         make(map[string]bool),
+		outbox:        make([]*OutboxEntry, 0),
+		digest:        make([]*OutboxEntry, 0),
+		failed:        make([]*OutboxEntry, 0),
+		choices:       make(map[string]string),
+		wake:          make(chan struct{}, 1),
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
@@ -2,19 +2,37 @@ package main
 
 import (
 	"log"
-	"math/rand"
+	"sync"
 	"time"
 )
 
 type Monitor struct {
 	observers map[string]map[int64]*Observer
+	states    map[string]*FeedState
+	mutex     sync.Mutex
 	ticker    *time.Ticker
-	quit      chan bool
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+type FeedState struct {
+	etag         string
+	lastModified string
+	schedule     *Schedule
+	next         time.Time
+	pulling      bool
+
+	failures    int
+	lastSuccess time.Time
+	lastError   error
+	broken      bool
 }
 
 type Observer struct {
 	identifier int64
 	handler    func(items map[string]*Item)
+	failure    func(state *FeedState)
+	recovery   func(state *FeedState)
 }
 
 func InitMonitor() {
@@ -26,12 +44,20 @@ func SharedMonitor() *Monitor {
 	monitorOnce.Do(func() {
 		monitor = &Monitor{
 			observers: make(map[string]map[int64]*Observer),
+			states:    make(map[string]*FeedState),
+			quit:      make(chan struct{}),
+			done:      make(chan struct{}),
 		}
 	})
 	return monitor
 }
 
+// AddObserver registers the observer, new links are due immediately and get
+// picked up by the next tick.
 func (monitor *Monitor) AddObserver(observer *Observer, link string) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
 	observers := monitor.observers[link]
 	if observers == nil {
 		observers = make(map[int64]*Observer)
@@ -39,34 +65,43 @@ func (monitor *Monitor) AddObserver(observer *Observer, link string) {
 	}
 
 	observers[observer.identifier] = observer
-	monitor.observers[link] = observers
-
-	monitor.Pull()
 }
 
 func (monitor *Monitor) RemoveObserver(identifier int64, link string) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
 	observers := monitor.observers[link]
 	if observers == nil {
 		return
 	}
 
 	delete(observers, identifier)
-	monitor.observers[link] = observers
+
+	if len(observers) == 0 {
+		delete(monitor.observers, link)
+		delete(monitor.states, link)
+	}
 }
 
 func (monitor *Monitor) Run() {
 	go monitor.Launch()
 }
 
+// Stop stops scheduling new fetches and waits for the running pull to finish.
 func (monitor *Monitor) Stop() {
-	monitor.quit <- true
+	close(monitor.quit)
+	<-monitor.done
 }
 
 func (monitor *Monitor) Launch() {
+	defer close(monitor.done)
+
 	monitor.Pull()
 
-	monitor.ticker = time.NewTicker(time.Duration(rand.Intn(60)+300) * time.Second)
-	monitor.quit = make(chan bool)
+	// Feeds carry their own schedules, the ticker only looks for the due ones.
+	monitor.ticker = time.NewTicker(10 * time.Second)
+	defer monitor.ticker.Stop()
 
 	for {
 		select {
@@ -79,22 +114,198 @@ func (monitor *Monitor) Launch() {
 }
 
 func (monitor *Monitor) Pull() {
+	links := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range links {
+				monitor.pull(link)
+			}
+		}()
+	}
+
+dispatch:
+	for _, link := range monitor.due(time.Now()) {
+		select {
+		case links <- link:
+		case <-monitor.quit:
+			break dispatch
+		}
+	}
+	close(links)
+
+	wg.Wait()
+}
+
+func (monitor *Monitor) pull(link string) {
+	state := monitor.acquire(link)
+	if state == nil {
+		return
+	}
+	defer monitor.release(state)
+
+	items, err := FetchItems(link, state)
+	if err != nil && err != errNotModified {
+		log.Printf("Fetch %s failed: %v", link, err)
+		monitor.fail(link, state, err)
+		return
+	}
+	monitor.succeed(link, state)
+
+	if len(items) == 0 {
+		return
+	}
+
+	for _, observer := range monitor.snapshot(link) {
+		if monitor.Stopped() {
+			return
+		}
+		if observer.handler == nil {
+			continue
+		}
+		observer.handler(items)
+	}
+}
+
+func (monitor *Monitor) fail(link string, state *FeedState, err error) {
+	now := time.Now()
+
+	monitor.mutex.Lock()
+	state.failures++
+	state.lastError = err
+	state.next = now.Add(backoff(state.failures))
+
+	notify := !state.broken && state.failures >= failureThreshold
+	if notify {
+		state.broken = true
+	}
+	// Observers get a copy, the state keeps changing under the lock.
+	copied := *state
+	monitor.mutex.Unlock()
+
+	if !notify {
+		return
+	}
+
+	for _, observer := range monitor.snapshot(link) {
+		if observer.failure == nil {
+			continue
+		}
+		observer.failure(&copied)
+	}
+}
+
+func (monitor *Monitor) succeed(link string, state *FeedState) {
+	now := time.Now()
+
+	monitor.mutex.Lock()
+	recovered := state.broken
+	state.failures = 0
+	state.lastError = nil
+	state.lastSuccess = now
+	state.broken = false
+	if state.schedule != nil {
+		state.next = state.schedule.Next(now)
+	} else {
+		state.next = now.Add(minInterval)
+	}
+	copied := *state
+	monitor.mutex.Unlock()
+
+	if !recovered {
+		return
+	}
+
+	for _, observer := range monitor.snapshot(link) {
+		if observer.recovery == nil {
+			continue
+		}
+		observer.recovery(&copied)
+	}
+}
+
+// backoff doubles the retry delay with every consecutive failure, capped by the
+// longest polling interval.
+func backoff(failures int) time.Duration {
+	delay := minInterval
+	for i := 1; i < failures && delay < maxInterval; i++ {
+		delay *= 2
+	}
+	if delay > maxInterval {
+		delay = maxInterval
+	}
+	return delay
+}
+
+func (monitor *Monitor) Stopped() bool {
+	select {
+	case <-monitor.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// snapshot copies the observers of the link so handlers run without the lock held.
+func (monitor *Monitor) snapshot(link string) []*Observer {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	observers := make([]*Observer, 0, len(monitor.observers[link]))
+	for _, observer := range monitor.observers[link] {
+		observers = append(observers, observer)
+	}
+
+	return observers
+}
+
+func (monitor *Monitor) due(now time.Time) []string {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	links := make([]string, 0)
 	for link, observers := range monitor.observers {
 		if len(observers) == 0 {
 			continue
 		}
 
-		items, err := FetchItems(link)
-		if len(items) == 0 || err != nil {
+		state := monitor.states[link]
+		if state != nil && now.Before(state.next) {
 			continue
 		}
 
-		for _, observer := range observers {
-			if observer.handler == nil {
-				continue
-			}
-			observer.handler(items)
-		}
+		links = append(links, link)
 	}
 
+	return links
+}
+
+// acquire marks the link as being pulled, it returns nil if another worker is
+// already on it so observers of the same link are never invoked concurrently.
+func (monitor *Monitor) acquire(link string) *FeedState {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	state := monitor.states[link]
+	if state == nil {
+		state = &FeedState{}
+		monitor.states[link] = state
+	}
+
+	if state.pulling {
+		return nil
+	}
+	state.pulling = true
+
+	return state
+}
+
+func (monitor *Monitor) release(state *FeedState) {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	state.pulling = false
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMonitorConcurrency adds and removes observers of failing and healthy
+// feeds while the monitor pulls them, run it with -race.
+func TestMonitorConcurrency(t *testing.T) {
+	server := newFeedServer(t)
+	monitor := SharedMonitor()
+
+	threshold := failureThreshold
+	failureThreshold = 1
+	defer func() { failureThreshold = threshold }()
+
+	links := []string{
+		server.URL + "/feed/monitor",
+		server.URL + "/flaky/monitor",
+	}
+
+	var handled, failed, recovered int64
+
+	newObserver := func(identifier int64) *Observer {
+		return &Observer{
+			identifier: identifier,
+			handler: func(items map[string]*Item) {
+				atomic.AddInt64(&handled, 1)
+			},
+			failure: func(state *FeedState) {
+				if state.failures == 0 || state.lastError == nil {
+					t.Error("failure reported without an error")
+				}
+				atomic.AddInt64(&failed, 1)
+			},
+			recovery: func(state *FeedState) {
+				if state.failures != 0 || state.lastSuccess.IsZero() {
+					t.Error("recovery reported without a success")
+				}
+				atomic.AddInt64(&recovered, 1)
+			},
+		}
+	}
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				monitor.Pull()
+			}
+		}
+	}()
+
+	var observers sync.WaitGroup
+	for identifier := int64(1); identifier <= 8; identifier++ {
+		observers.Add(1)
+		go func(identifier int64) {
+			defer observers.Done()
+			link := links[identifier%int64(len(links))]
+			for round := 0; round < 50; round++ {
+				monitor.AddObserver(newObserver(identifier), link)
+				time.Sleep(time.Millisecond)
+				if round%2 == 1 {
+					monitor.RemoveObserver(identifier, link)
+				}
+			}
+		}(identifier)
+	}
+
+	observers.Wait()
+
+	// The observers left in place keep being notified for a while.
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	background.Wait()
+
+	for identifier := int64(1); identifier <= 8; identifier++ {
+		monitor.RemoveObserver(identifier, links[identifier%int64(len(links))])
+	}
+
+	if atomic.LoadInt64(&handled) == 0 {
+		t.Error("no items handled")
+	}
+	if atomic.LoadInt64(&failed) == 0 || atomic.LoadInt64(&recovered) == 0 {
+		t.Error(fmt.Sprintf("%d failures and %d recoveries reported", failed, recovered))
+	}
+}
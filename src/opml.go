@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+)
+
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type OPMLBody struct {
+	Outlines []*OPMLOutline `xml:"outline"`
+}
+
+// OPMLOutline is a feed when it has an XMLURL, otherwise a category of the
+// outlines inside.
+type OPMLOutline struct {
+	Text     string         `xml:"text,attr"`
+	Title    string         `xml:"title,attr,omitempty"`
+	Type     string         `xml:"type,attr,omitempty"`
+	XMLURL   string         `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string         `xml:"htmlUrl,attr,omitempty"`
+	Category string         `xml:"category,attr,omitempty"`
+	Outlines []*OPMLOutline `xml:"outline"`
+}
+
+// OPMLFeed is a feed of an OPML document with the categories it is filed under.
+type OPMLFeed struct {
+	URL   string
+	Title string
+	Tags  []string
+}
+
+// ParseOPML returns the feeds of the document once each, in document order.
+func ParseOPML(data []byte) ([]*OPMLFeed, error) {
+	var opml OPML
+	err := xml.Unmarshal(data, &opml)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds := make([]*OPMLFeed, 0)
+	seen := make(map[string]*OPMLFeed)
+
+	var walk func(outlines []*OPMLOutline, categories []string)
+	walk = func(outlines []*OPMLOutline, categories []string) {
+		for _, outline := range outlines {
+			title := outline.Title
+			if len(title) == 0 {
+				title = outline.Text
+			}
+
+			url := strings.TrimSpace(outline.XMLURL)
+			if len(url) == 0 {
+				walk(outline.Outlines, appendTags(categories, title))
+				continue
+			}
+
+			tags := appendTags(categories, splitCategories(outline.Category)...)
+
+			// Feeds filed under several categories are listed once per category.
+			if feed := seen[url]; feed != nil {
+				feed.Tags = appendTags(feed.Tags, tags...)
+				continue
+			}
+
+			feed := &OPMLFeed{
+				URL:   url,
+				Title: title,
+				Tags:  tags,
+			}
+			seen[url] = feed
+			feeds = append(feeds, feed)
+		}
+	}
+	walk(opml.Body.Outlines, nil)
+
+	return feeds, nil
+}
+
+// NewOPML writes the subscriptions as an OPML document, tags go into the
+// category attribute.
+func NewOPML(title string, subscriptions []*Subscription) ([]byte, error) {
+	opml := OPML{
+		Version: "2.0",
+		Head: OPMLHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123Z),
+		},
+	}
+
+	for _, subscription := range subscriptions {
+		outline := &OPMLOutline{
+			Text:   subscription.Title,
+			Title:  subscription.Title,
+			Type:   "rss",
+			XMLURL: subscription.Link,
+		}
+		if len(subscription.Tags) > 0 {
+			outline.Category = strings.Join(subscription.Tags, ",")
+		}
+		opml.Body.Outlines = append(opml.Body.Outlines, outline)
+	}
+
+	data, err := xml.MarshalIndent(opml, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
+
+// splitCategories splits the category attribute, a comma separated list of
+// paths such as "/Tech/Go,News".
+func splitCategories(category string) []string {
+	tags := make([]string, 0)
+	for _, path := range strings.Split(category, ",") {
+		for _, tag := range strings.Split(path, "/") {
+			tags = appendTags(tags, tag)
+		}
+	}
+	return tags
+}
+
+// appendTags adds the tags missing from the list, it never modifies the list given.
+func appendTags(tags []string, more ...string) []string {
+	result := append([]string{}, tags...)
+	for _, tag := range more {
+		tag = strings.TrimSpace(tag)
+		if len(tag) > 0 && !containsString(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseOPML(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		feeds    []string
+	}{
+		{
+			name: "nested outlines",
+			document: `<opml version="2.0"><body>
+<outline text="Tech">
+	<outline text="Go">
+		<outline text="Go Blog" xmlUrl="https://go.dev/blog/feed.atom"/>
+	</outline>
+	<outline title="Rust" text="rust">
+		<outline text="This Week in Rust" xmlUrl=" https://this-week-in-rust.org/rss.xml " category="/News"/>
+	</outline>
+</outline>
+<outline text="Top level" xmlUrl="https://example.com/feed"/>
+</body></opml>`,
+			feeds: []string{
+				"https://go.dev/blog/feed.atom Go Blog [Tech Go]",
+				"https://this-week-in-rust.org/rss.xml This Week in Rust [Tech Rust News]",
+				"https://example.com/feed Top level []",
+			},
+		},
+		{
+			name: "missing xmlUrl",
+			document: `<opml version="1.0"><body>
+<outline text="No feed" htmlUrl="https://example.com"/>
+<outline text="Blank feed" xmlUrl="  "/>
+<outline text="Feed" xmlUrl="https://example.com/feed"/>
+</body></opml>`,
+			feeds: []string{"https://example.com/feed Feed []"},
+		},
+		{
+			name: "duplicates",
+			document: `<opml version="2.0"><body>
+<outline text="News"><outline text="Feed" xmlUrl="https://example.com/feed"/></outline>
+<outline text="Daily"><outline text="Feed again" xmlUrl="https://example.com/feed" category="News,Tech/Go"/></outline>
+</body></opml>`,
+			feeds: []string{"https://example.com/feed Feed [News Daily Tech Go]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feeds, err := ParseOPML([]byte(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]string, 0, len(feeds))
+			for _, feed := range feeds {
+				got = append(got, fmt.Sprintf("%s %s %v", feed.URL, feed.Title, feed.Tags))
+			}
+			if strings.Join(got, "\n") != strings.Join(test.feeds, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.feeds, "\n"))
+			}
+		})
+	}
+
+	if _, err := ParseOPML([]byte("not xml")); err == nil {
+		t.Error("no error for a document that is not OPML")
+	}
+}
+
+// TestDownloadError keeps the bot token of file urls out of the errors.
+func TestDownloadError(t *testing.T) {
+	_, err := http.Get("http://127.0.0.1:1/file/bot123456:secret-token/documents/file_1.opml")
+	if err == nil {
+		t.Skip("nothing should listen on port 1")
+	}
+
+	if message := withoutURL(err).Error(); strings.Contains(message, "secret-token") {
+		t.Errorf("the token is in %q", message)
+	}
+}
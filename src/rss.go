@@ -1,27 +1,50 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mmcdole/gofeed"
 )
 
+var errNotModified = errors.New("feed not modified")
+
+// maxFeedSize caps the documents fetched, feeds and the pages searched for them.
+const maxFeedSize = 10 << 20
+
+// FetchChannel fetches the feed at url. A web page is searched for its feeds,
+// the only one found is fetched instead and a *FeedChoiceError lists them when
+// there are several.
 func FetchChannel(url string) (*Channel, []*Item, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, nil, err
+	channel, items, err := fetchChannel(url)
+
+	var page *NotFeedError
+	if !errors.As(err, &page) || page.body == nil {
+		return channel, items, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.97 Safari/537.36")
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, nil, err
+	feeds := Discover(page.URL, page.body)
+	switch len(feeds) {
+	case 0:
+		return nil, nil, &NotFeedError{URL: url}
+	case 1:
+		return fetchChannel(feeds[0].URL)
+	default:
+		return nil, nil, &FeedChoiceError{URL: url, Feeds: feeds}
 	}
+}
 
-	parser := gofeed.NewParser()
-	feed, err := parser.Parse(resp.Body)
+func fetchChannel(url string) (*Channel, []*Item, error) {
+	feed, err := fetch(url, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -31,50 +54,185 @@ func FetchChannel(url string) (*Channel, []*Item, error) {
 		title:       feed.Title,
 		description: feed.Description,
 		link:        url,
+		site:        feed.Link,
+		language:    feed.Language,
+		authors:     names(feed.Authors),
+		categories:  feed.Categories,
+	}
+	if feed.Image != nil {
+		channel.image = feed.Image.URL
+	}
+	if feed.UpdatedParsed != nil {
+		channel.updated = *feed.UpdatedParsed
 	}
 
 	var items []*Item
 	for index := len(feed.Items) - 1; index >= 0; index-- {
-		item := feed.Items[index]
-		items = append(items, &Item{
-			id:    fmt.Sprintf("%x", md5.Sum([]byte(item.GUID))),
-			title: item.Title,
-			link:  item.Link,
-		})
+		items = append(items, newItem(feed.Items[index]))
 	}
 
 	return channel, items, nil
 }
 
-func FetchItems(url string) (map[string]*Item, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// FetchItems fetches the feed conditionally on the validators kept in state,
+// errNotModified is returned when the publisher reports no changes.
+func FetchItems(url string, state *FeedState) (map[string]*Item, error) {
+	feed, err := fetch(url, state)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]*Item)
+
+	for index := len(feed.Items) - 1; index >= 0; index-- {
+		item := newItem(feed.Items[index])
+		items[item.id] = item
+	}
+
+	return items, nil
+}
+
+func newItem(item *gofeed.Item) *Item {
+	result := &Item{
+		id:          fmt.Sprintf("%x", md5.Sum([]byte(item.GUID))),
+		title:       item.Title,
+		link:        item.Link,
+		description: item.Description,
+		content:     item.Content,
+		authors:     names(item.Authors),
+		categories:  item.Categories,
+		comments:    item.Custom["comments"],
+	}
+
+	if item.PublishedParsed != nil {
+		result.published = *item.PublishedParsed
+	}
+	if item.UpdatedParsed != nil {
+		result.updated = *item.UpdatedParsed
+	}
+	if result.published.IsZero() {
+		result.published = result.updated
+	}
+
+	for _, enclosure := range item.Enclosures {
+		if enclosure == nil || len(enclosure.URL) == 0 {
+			continue
+		}
+		length, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+		result.enclosures = append(result.enclosures, &Enclosure{
+			url:    enclosure.URL,
+			kind:   enclosure.Type,
+			length: length,
+		})
+	}
+
+	if item.Image != nil {
+		result.image = item.Image.URL
+	} else if item.ITunesExt != nil && len(item.ITunesExt.Image) > 0 {
+		result.image = item.ITunesExt.Image
+	} else {
+		for _, enclosure := range result.enclosures {
+			if strings.HasPrefix(enclosure.kind, "image/") {
+				result.image = enclosure.url
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func names(people []*gofeed.Person) []string {
+	var names []string
+	for _, person := range people {
+		if person != nil && len(person.Name) > 0 {
+			names = append(names, person.Name)
+		}
+	}
+	return names
+}
+
+// SortItems orders the items by publish date, oldest first, so they are pushed
+// in the order they were written.
+func SortItems(items map[string]*Item) []*Item {
+	sorted := make([]*Item, 0, len(items))
+	for _, item := range items {
+		sorted = append(sorted, item)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].published.Equal(sorted[j].published) {
+			return sorted[i].published.Before(sorted[j].published)
+		}
+		return sorted[i].id < sorted[j].id
+	})
+
+	return sorted
+}
+
+func fetch(url string, state *FeedState) (*gofeed.Feed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.4103.97 Safari/537.36")
 
+	if state != nil {
+		if len(state.etag) > 0 {
+			req.Header.Set("If-None-Match", state.etag)
+		}
+		if len(state.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", state.lastModified)
+		}
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, &NetworkError{URL: url, Err: err}
 	}
+	defer resp.Body.Close()
 
-	parser := gofeed.NewParser()
-	feed, err := parser.Parse(resp.Body)
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, &StatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedSize+1))
 	if err != nil {
-		return nil, err
+		return nil, &NetworkError{URL: url, Err: err}
+	}
+	if len(body) > maxFeedSize {
+		return nil, &TooLargeError{URL: url, Limit: maxFeedSize}
 	}
 
-	items := make(map[string]*Item)
+	translator := &rssTranslator{}
 
-	for index := len(feed.Items) - 1; index >= 0; index-- {
-		item := feed.Items[index]
-		id := fmt.Sprintf("%x", md5.Sum([]byte(item.GUID)))
-		items[id] = &Item{
-			id:    fmt.Sprintf("%x", md5.Sum([]byte(item.GUID))),
-			title: item.Title,
-			link:  item.Link,
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = translator
+	feed, err := parser.Parse(bytes.NewReader(body))
+	if err == gofeed.ErrFeedTypeNotDetected {
+		// Only feeds being added look for the feeds of a web page.
+		if state == nil && isHTML(resp.Header.Get("Content-Type"), body) {
+			return nil, &NotFeedError{URL: resp.Request.URL.String(), body: body}
 		}
+		return nil, &NotFeedError{URL: url}
+	}
+	if err != nil {
+		return nil, &ParseError{URL: url, Err: err}
 	}
 
-	return items, nil
+	// Only remember validators once the body has been parsed, otherwise a broken
+	// response would be skipped forever.
+	if state != nil {
+		state.etag = resp.Header.Get("ETag")
+		state.lastModified = resp.Header.Get("Last-Modified")
+		state.schedule = NewSchedule(feed, translator.source, time.Now())
+	}
+
+	return feed, nil
 }
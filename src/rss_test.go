@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetchTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Large</title><description>`)
+		fmt.Fprint(w, strings.Repeat("x", maxFeedSize))
+		fmt.Fprint(w, `</description></channel></rss>`)
+	}))
+	defer server.Close()
+
+	_, _, err := FetchChannel(server.URL)
+
+	var tooLarge *TooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("got %v, want a *TooLargeError", err)
+	}
+}
+
+// TestFetchNotModified sends the validators of the last response and keeps
+// them, along with the schedule, when the feed did not change.
+func TestFetchNotModified(t *testing.T) {
+	const lastModified = "Sat, 01 May 2021 08:00:00 GMT"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/etag":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+		case "/last-modified":
+			if r.Header.Get("If-Modified-Since") == lastModified {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Last-Modified", lastModified)
+		}
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Feed</title><link>https://example.com</link><item><title>Item</title><guid>item</guid></item></channel></rss>`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path      string
+		unchanged bool
+	}{
+		{"/etag", true},
+		{"/last-modified", true},
+		{"/no-validators", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			state := &FeedState{}
+
+			items, err := FetchItems(server.URL+test.path, state)
+			if err != nil || len(items) != 1 {
+				t.Fatalf("first fetch: %d items, %v", len(items), err)
+			}
+			etag, modified, schedule := state.etag, state.lastModified, state.schedule
+
+			items, err = FetchItems(server.URL+test.path, state)
+			if test.unchanged {
+				if err != errNotModified || items != nil {
+					t.Fatalf("second fetch: %d items, %v", len(items), err)
+				}
+				if state.etag != etag || state.lastModified != modified || state.schedule != schedule {
+					t.Error("the validators or the schedule changed")
+				}
+			} else if err != nil || len(items) != 1 {
+				t.Fatalf("second fetch: %d items, %v", len(items), err)
+			}
+		})
+	}
+}
+
+// TestMonitorNotModified leaves the observers alone when the feed did not change.
+func TestMonitorNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `<?xml version="1.0"?><rss version="2.0"><channel><title>Feed</title><link>https://example.com</link><item><title>Item</title><guid>item</guid></item></channel></rss>`)
+	}))
+	defer server.Close()
+
+	link := server.URL + "/not-modified"
+	monitor := SharedMonitor()
+
+	handled, failed := 0, 0
+	monitor.AddObserver(&Observer{
+		identifier: 1,
+		handler: func(items map[string]*Item) {
+			handled++
+		},
+		failure: func(state *FeedState) {
+			failed++
+		},
+	}, link)
+	defer monitor.RemoveObserver(1, link)
+
+	monitor.pull(link)
+	monitor.pull(link)
+
+	if handled != 1 || failed != 0 {
+		t.Errorf("%d handled and %d failed, want the first pull handled only", handled, failed)
+	}
+
+	monitor.mutex.Lock()
+	failures := monitor.states[link].failures
+	monitor.mutex.Unlock()
+	if failures != 0 {
+		t.Errorf("%d failures counted", failures)
+	}
+}
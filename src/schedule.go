@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// rssTranslator keeps the raw RSS channel around, the universal feed drops the
+// ttl, skipHours and skipDays hints, and the comments link of the items.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+	source *rss.Feed
+}
+
+func (translator *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	source, ok := feed.(*rss.Feed)
+	if ok {
+		translator.source = source
+	}
+
+	translated, err := translator.DefaultRSSTranslator.Translate(feed)
+	if err != nil || !ok {
+		return translated, err
+	}
+
+	// Items are translated one to one and in order.
+	for index, item := range source.Items {
+		if index >= len(translated.Items) || len(item.Comments) == 0 {
+			continue
+		}
+		if translated.Items[index].Custom == nil {
+			translated.Items[index].Custom = make(map[string]string)
+		}
+		translated.Items[index].Custom["comments"] = item.Comments
+	}
+
+	return translated, nil
+}
+
+type Schedule struct {
+	ttl       time.Duration
+	period    time.Duration
+	rate      time.Duration
+	skipHours map[int]bool
+	skipDays  map[time.Weekday]bool
+}
+
+func NewSchedule(feed *gofeed.Feed, source *rss.Feed, now time.Time) *Schedule {
+	schedule := &Schedule{
+		skipHours: make(map[int]bool),
+		skipDays:  make(map[time.Weekday]bool),
+	}
+
+	if source != nil {
+		if ttl, err := strconv.Atoi(strings.TrimSpace(source.TTL)); err == nil && ttl > 0 {
+			schedule.ttl = time.Duration(ttl) * time.Minute
+		}
+		for _, hour := range source.SkipHours {
+			if hour, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil {
+				schedule.skipHours[hour%24] = true
+			}
+		}
+		for _, day := range source.SkipDays {
+			for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+				if strings.EqualFold(strings.TrimSpace(day), weekday.String()) {
+					schedule.skipDays[weekday] = true
+				}
+			}
+		}
+	}
+
+	schedule.period = updatePeriod(feed)
+	schedule.rate = publishingRate(feed, now)
+
+	return schedule
+}
+
+// Interval returns how long to wait before polling again, bounded by the
+// configured min and max intervals.
+func (schedule *Schedule) Interval() time.Duration {
+	// Poll twice per publishing interval so new items are picked up reasonably fast.
+	interval := schedule.rate / 2
+	if interval < schedule.ttl {
+		interval = schedule.ttl
+	}
+	if interval < schedule.period {
+		interval = schedule.period
+	}
+
+	if interval < minInterval {
+		interval = minInterval
+	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+
+	return interval
+}
+
+// Next returns the next poll time after now, skipping the hours and days the
+// publisher asked to be left alone.
+func (schedule *Schedule) Next(now time.Time) time.Time {
+	interval := schedule.Interval()
+
+	// Spread polls a bit so feeds fetched together don't stay in lockstep.
+	jitter := time.Duration(rand.Int63n(int64(interval)/10 + 1))
+	next := now.Add(interval + jitter)
+
+	for i := 0; i < 7*24; i++ {
+		utc := next.UTC()
+		if !schedule.skipHours[utc.Hour()] && !schedule.skipDays[utc.Weekday()] {
+			break
+		}
+		next = utc.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return next
+}
+
+func updatePeriod(feed *gofeed.Feed) time.Duration {
+	sy := feed.Extensions["sy"]
+	if sy == nil || len(sy["updatePeriod"]) == 0 {
+		return 0
+	}
+
+	var period time.Duration
+	switch strings.TrimSpace(sy["updatePeriod"][0].Value) {
+	case "hourly":
+		period = time.Hour
+	case "daily":
+		period = 24 * time.Hour
+	case "weekly":
+		period = 7 * 24 * time.Hour
+	case "monthly":
+		period = 30 * 24 * time.Hour
+	case "yearly":
+		period = 365 * 24 * time.Hour
+	default:
+		return 0
+	}
+
+	if len(sy["updateFrequency"]) > 0 {
+		if frequency, err := strconv.Atoi(strings.TrimSpace(sy["updateFrequency"][0].Value)); err == nil && frequency > 0 {
+			period /= time.Duration(frequency)
+		}
+	}
+
+	return period
+}
+
+// publishingRate estimates the average gap between recent items, a feed that
+// has been silent for longer than that is treated as slower.
+func publishingRate(feed *gofeed.Feed, now time.Time) time.Duration {
+	var dates []time.Time
+	for _, item := range feed.Items {
+		if item.PublishedParsed != nil {
+			dates = append(dates, *item.PublishedParsed)
+		} else if item.UpdatedParsed != nil {
+			dates = append(dates, *item.UpdatedParsed)
+		}
+	}
+
+	if len(dates) < 2 {
+		return maxInterval
+	}
+
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].After(dates[j])
+	})
+	if len(dates) > 10 {
+		dates = dates[:10]
+	}
+
+	rate := dates[0].Sub(dates[len(dates)-1]) / time.Duration(len(dates)-1)
+	if silence := now.Sub(dates[0]); silence > rate {
+		rate = silence
+	}
+
+	return rate
+}
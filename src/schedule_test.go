@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// withIntervals sets the polling bounds for the duration of the test.
+func withIntervals(t *testing.T, min, max time.Duration) {
+	previousMin, previousMax := minInterval, maxInterval
+	minInterval, maxInterval = min, max
+	t.Cleanup(func() {
+		minInterval, maxInterval = previousMin, previousMax
+	})
+}
+
+func TestScheduleInterval(t *testing.T) {
+	withIntervals(t, 5*time.Minute, 24*time.Hour)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		interval time.Duration
+	}{
+		{
+			name:     "half the publishing rate",
+			schedule: Schedule{rate: time.Hour},
+			interval: 30 * time.Minute,
+		},
+		{
+			name:     "ttl above the rate",
+			schedule: Schedule{rate: time.Hour, ttl: 2 * time.Hour},
+			interval: 2 * time.Hour,
+		},
+		{
+			name:     "update period above the ttl",
+			schedule: Schedule{rate: time.Hour, ttl: 2 * time.Hour, period: 6 * time.Hour},
+			interval: 6 * time.Hour,
+		},
+		{
+			name:     "clamped to the min interval",
+			schedule: Schedule{rate: time.Minute},
+			interval: 5 * time.Minute,
+		},
+		{
+			name:     "clamped to the max interval",
+			schedule: Schedule{rate: time.Hour, period: 7 * 24 * time.Hour},
+			interval: 24 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if interval := test.schedule.Interval(); interval != test.interval {
+				t.Errorf("got %v, want %v", interval, test.interval)
+			}
+		})
+	}
+}
+
+// TestPublishingRate slows down for feeds that stopped publishing.
+func TestPublishingRate(t *testing.T) {
+	withIntervals(t, 5*time.Minute, 24*time.Hour)
+
+	now := time.Date(2021, 5, 1, 12, 0, 0, 0, time.UTC)
+	feed := func(ages ...time.Duration) *gofeed.Feed {
+		feed := &gofeed.Feed{}
+		for _, age := range ages {
+			published := now.Add(-age)
+			feed.Items = append(feed.Items, &gofeed.Item{PublishedParsed: &published})
+		}
+		return feed
+	}
+
+	tests := []struct {
+		name string
+		feed *gofeed.Feed
+		rate time.Duration
+	}{
+		{
+			name: "single item",
+			feed: feed(time.Hour),
+			rate: 24 * time.Hour,
+		},
+		{
+			name: "average gap",
+			feed: feed(0, time.Hour, 2*time.Hour, 3*time.Hour),
+			rate: time.Hour,
+		},
+		{
+			name: "silent feed",
+			feed: feed(10*time.Hour, 11*time.Hour, 12*time.Hour),
+			rate: 10 * time.Hour,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rate := publishingRate(test.feed, now); rate != test.rate {
+				t.Errorf("got %v, want %v", rate, test.rate)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	withIntervals(t, time.Minute, 10*time.Minute)
+
+	tests := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{5, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+
+	for _, test := range tests {
+		if delay := backoff(test.failures); delay != test.delay {
+			t.Errorf("%d failures: got %v, want %v", test.failures, delay, test.delay)
+		}
+	}
+}
+
+// TestScheduleNext moves the next poll out of the skipped hours and days.
+func TestScheduleNext(t *testing.T) {
+	withIntervals(t, time.Hour, time.Hour)
+
+	// A Saturday.
+	now := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		earliest time.Time
+		latest   time.Time
+	}{
+		{
+			name:     "nothing skipped",
+			earliest: now.Add(time.Hour),
+			latest:   now.Add(time.Hour + 6*time.Minute),
+		},
+		{
+			name:     "skipped hours",
+			schedule: Schedule{skipHours: map[int]bool{11: true, 12: true}},
+			earliest: time.Date(2021, 5, 1, 13, 0, 0, 0, time.UTC),
+			latest:   time.Date(2021, 5, 1, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "skipped days",
+			schedule: Schedule{skipDays: map[time.Weekday]bool{time.Saturday: true, time.Sunday: true}},
+			earliest: time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC),
+			latest:   time.Date(2021, 5, 3, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := test.schedule.Next(now)
+			if next.Before(test.earliest) || next.After(test.latest) {
+				t.Errorf("got %v, want between %v and %v", next, test.earliest, test.latest)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// HandleCallback runs the button pressed below a message and edits the message
+// to reflect the new state.
+func (session *Session) HandleCallback(query *tgbotapi.CallbackQuery) {
+	fields := strings.Split(query.Data, ":")
+	if len(fields) < 3 || query.Message == nil {
+		session.Answer(query, "")
+		return
+	}
+
+	chatID, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		session.Answer(query, "")
+		return
+	}
+	page, _ := strconv.Atoi(fields[2])
+
+	// The buttons were sent for a chat the bot knows, anything else is forged
+	// and must not set up a chat.
+	target := FindContext(chatID)
+	if target == nil {
+		session.Answer(query, "")
+		return
+	}
+
+	if response := session.AuthorizeCallback(target, query); len(response) > 0 {
+		session.Answer(query, SharedFormatter().Plain(response))
+		return
+	}
+
+	var subscription *Subscription
+	if len(fields) > 3 {
+		subscription = target.GetSubscription(fields[3])
+	}
+
+	var notice string
+	var text string
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+
+	switch {
+	case fields[0] == "l":
+		text, keyboard = target.ListPage(page)
+
+	case fields[0] == "a" && len(fields) > 3:
+		url := target.Offered(fields[3])
+		if len(url) == 0 {
+			session.Answer(query, "This choice expired, please send the page again.")
+			return
+		}
+		text, keyboard = target.HandleSubscribeCommand(url)
+
+	case subscription == nil:
+		notice = "This subscription no longer exists."
+		text, keyboard = target.ListPage(page)
+
+	case fields[0] == "s":
+		text, keyboard = target.SubscriptionPage(subscription, page)
+
+	case fields[0] == "u":
+		notice = SharedFormatter().Plain(target.HandleUnsubscribeCommand(subscription.Link))
+		text, keyboard = target.ListPage(page)
+
+	case fields[0] == "p":
+		notice = SharedFormatter().Plain(target.HandlePauseCommand(subscription.Link, !target.IsPaused(subscription)))
+		text, keyboard = target.SubscriptionPage(subscription, page)
+
+	case fields[0] == "f":
+		text, keyboard = target.FilterPage(subscription, page)
+
+	case fields[0] == "v":
+		// Previews are sent on their own, they may be long.
+		session.Answer(query, "")
+		err := session.Send(query.Message.Chat.ID, target.HandleTemplateCommand(subscription.Link+" preview"))
+		if err != nil {
+			log.Println(err)
+		}
+		return
+
+	default:
+		session.Answer(query, "")
+		return
+	}
+
+	session.Answer(query, notice)
+
+	err = session.Edit(query.Message.Chat.ID, query.Message.MessageID, text, keyboard)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// AuthorizeCallback checks the user pressing the button may manage the chat,
+// the response is set when they may not.
+func (session *Session) AuthorizeCallback(target *Context, query *tgbotapi.CallbackQuery) string {
+	chat := query.Message.Chat
+
+	// Anyone reading a channel can press its buttons.
+	if target.id != chat.ID || chat.IsChannel() {
+		if query.From == nil {
+			return format(`Only administrators can do this in this chat.`)
+		}
+		if admin, err := session.IsAdministrator(target.id, query.From.ID); err != nil || !admin {
+			return format(`Only administrators can do this in this chat.`)
+		}
+		return ""
+	}
+
+	if chat.IsPrivate() {
+		return ""
+	}
+
+	return session.authorize(target, chat.ID, query.From, false)
+}
+
+// Answer stops the spinner of the button, the text is shown as a notification.
+func (session *Session) Answer(query *tgbotapi.CallbackQuery, text string) {
+	_, err := session.bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// Edit replaces the text and the keyboard of a message sent earlier.
+func (session *Session) Edit(chatID int64, messageID int, message string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	formatter := SharedFormatter()
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, message)
+	edit.ParseMode = formatter.Mode()
+	edit.ReplyMarkup = keyboard
+
+	_, err := session.Deliver(chatID, edit)
+	if isParseError(err) {
+		edit.Text = formatter.Plain(message)
+		edit.ParseMode = ""
+		_, err = session.Deliver(chatID, edit)
+	}
+
+	// Pressing a button twice leaves nothing to change.
+	if apiErr, ok := err.(tgbotapi.Error); ok && strings.Contains(apiErr.Message, "message is not modified") {
+		return nil
+	}
+
+	return err
+}
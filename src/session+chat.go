@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func chatKind(chat *tgbotapi.Chat) int {
+	kind := 0
+	if chat.IsPrivate() {
+		kind = 0
+	} else if chat.IsGroup() || chat.IsSuperGroup() {
+		kind = 1
+	} else if chat.IsChannel() {
+		kind = 2
+	}
+	return kind
+}
+
+func (session *Session) ChatMemberStatus(chatID int64, userID int) (string, error) {
+	member, err := session.bot.GetChatMember(tgbotapi.ChatConfigWithUser{
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return member.Status, nil
+}
+
+func (session *Session) IsAdministrator(chatID int64, userID int) (bool, error) {
+	status, err := session.ChatMemberStatus(chatID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return status == "creator" || status == "administrator", nil
+}
+
+// Authorize checks the sender may manage the subscriptions of the chat, the
+// response is set when they may not. Settings are always reserved to administrators.
+func (session *Session) Authorize(context *Context, message *Message, settings bool) string {
+	// Only administrators can post in channels, and private chats belong to the user.
+	if message.Chat.IsPrivate() || message.Chat.IsChannel() {
+		return ""
+	}
+
+	// Anonymous administrators write on behalf of the group itself, anyone
+	// else writing on behalf of a chat, such as a channel, is no member.
+	if message.SenderChat != nil {
+		if message.SenderChat.ID == message.Chat.ID {
+			return ""
+		}
+		return format(`Only administrators can do this in this chat.`)
+	}
+
+	return session.authorize(context, message.Chat.ID, message.From, settings)
+}
+
+// authorize checks the user may manage the subscriptions of the group.
+func (session *Session) authorize(context *Context, chatID int64, user *tgbotapi.User, settings bool) string {
+	if user == nil {
+		return format(`Only administrators can do this in this chat.`)
+	}
+
+	permission, allowlist := context.GetPermission()
+	if permission == PermissionEveryone && !settings {
+		return ""
+	}
+
+	if admin, err := session.IsAdministrator(chatID, user.ID); err != nil {
+		return format(`Unable to check your permissions, please try again later.`)
+	} else if admin {
+		return ""
+	}
+
+	if permission == PermissionAllowlist && !settings {
+		for _, id := range allowlist {
+			if id == int64(user.ID) {
+				return ""
+			}
+		}
+		return format(`You are not on the list of members allowed to manage subscriptions in this chat.`)
+	}
+
+	return format(`Only administrators can do this in this chat.`)
+}
+
+// Manage resolves the chat like Target and additionally authorizes the sender
+// for the chat the command was sent in.
+func (session *Session) Manage(context *Context, message *Message) (*Context, string, string) {
+	target, args, response := session.Target(context, message)
+	if target == context {
+		if response = session.Authorize(context, message, false); len(response) > 0 {
+			return nil, args, response
+		}
+	}
+	return target, args, response
+}
+
+// Target resolves the chat a command manages. "/add @channel <url>" manages a
+// channel remotely, which requires both the bot and the requester to be its
+// administrators. The response is set when the command must not go through.
+func (session *Session) Target(context *Context, message *Message) (*Context, string, string) {
+	args := strings.TrimSpace(message.CommandArguments())
+	if !strings.HasPrefix(args, "@") {
+		return context, args, ""
+	}
+
+	name := args
+	args = ""
+	if index := strings.IndexAny(name, " \t\n"); index >= 0 {
+		name, args = name[:index], strings.TrimSpace(name[index+1:])
+	}
+
+	if message.From == nil {
+		return nil, args, format(`Remote management requires a user.`)
+	}
+
+	chat, err := session.bot.GetChat(tgbotapi.ChatConfig{SuperGroupUsername: name})
+	if err != nil || !chat.IsChannel() {
+		return nil, args, format(`Unable to find the channel %s.`, name)
+	}
+
+	if admin, err := session.IsAdministrator(chat.ID, session.bot.Self.ID); err != nil || !admin {
+		return nil, args, format(`Please make me an administrator of %s first.`, name)
+	}
+
+	if admin, err := session.IsAdministrator(chat.ID, message.From.ID); err != nil || !admin {
+		return nil, args, format(`Only administrators of %s can manage its subscriptions.`, name)
+	}
+
+	target, err := NewContext(chat.ID, chatKind(&chat))
+	if err == nil {
+		err = target.Activate()
+	}
+	if err != nil {
+		return nil, args, format(`Oops, something wrong happened.`)
+	}
+
+	return target, args, ""
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// maxOPMLSize caps the documents /import downloads.
+	maxOPMLSize = 1 << 20
+	// progressInterval spaces the edits of the import progress message.
+	progressInterval = 5 * time.Second
+)
+
+// HandleExportCommand sends the subscriptions of the target as an OPML
+// document, the response is set when there is nothing to send.
+func (session *Session) HandleExportCommand(target *Context, message *tgbotapi.Message) string {
+	if len(target.GetSubscriptions()) == 0 {
+		return format(`Your list is empty.`)
+	}
+
+	data, err := target.ExportOPML()
+	if err != nil {
+		log.Println(err)
+		return format(`Oops, something wrong happened.`)
+	}
+
+	document := tgbotapi.NewDocumentUpload(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  "subscriptions.opml",
+		Bytes: data,
+	})
+	document.ReplyToMessageID = message.MessageID
+
+	_, err = session.Deliver(message.Chat.ID, document)
+	if err != nil {
+		log.Println(err)
+		return format(`Oops, something wrong happened.`)
+	}
+
+	return ""
+}
+
+// HandleImportCommand subscribes the target to the feeds of the OPML document
+// sent along with the command or replied to. The feeds are imported in the
+// background, the response is set when the import does not start.
+func (session *Session) HandleImportCommand(target *Context, message *tgbotapi.Message) string {
+	document := message.Document
+	if document == nil && message.ReplyToMessage != nil {
+		document = message.ReplyToMessage.Document
+	}
+	if document == nil {
+		return format(`Send an OPML file with /import as its caption, or reply /import to one.`)
+	}
+	if document.FileSize > maxOPMLSize {
+		return format(`The file is too large, OPML files up to %d KB are accepted.`, maxOPMLSize/1024)
+	}
+
+	data, err := session.download(document.FileID, maxOPMLSize)
+	if err != nil {
+		log.Println(err)
+		return format(`Unable to download the file, please try again later.`)
+	}
+
+	feeds, err := ParseOPML(data)
+	if err != nil {
+		return format("Unable to read the file as OPML: %s", code(err.Error()))
+	}
+	if len(feeds) == 0 {
+		return format(`The file has no feeds.`)
+	}
+
+	session.importsMutex.Lock()
+	if session.imports[target.id] {
+		session.importsMutex.Unlock()
+		return format(`An import is already running in this chat, please wait for it to finish.`)
+	}
+	session.imports[target.id] = true
+	session.importing.Add(1)
+	session.importsMutex.Unlock()
+
+	go func() {
+		defer func() {
+			session.importsMutex.Lock()
+			delete(session.imports, target.id)
+			session.importsMutex.Unlock()
+			session.importing.Done()
+		}()
+
+		session.importFeeds(target, message, feeds)
+	}()
+
+	return ""
+}
+
+// importFeeds subscribes to the feeds one by one, reporting the progress in a
+// message edited along the way. It stops early when the session stops.
+func (session *Session) importFeeds(target *Context, message *tgbotapi.Message, feeds []*OPMLFeed) {
+	chatID := message.Chat.ID
+
+	msg := tgbotapi.NewMessage(chatID, format("Importing %d feeds…", len(feeds)))
+	msg.ParseMode = SharedFormatter().Mode()
+	msg.ReplyToMessageID = message.MessageID
+	progress, err := session.Deliver(chatID, msg)
+	if err != nil {
+		log.Println(err)
+	}
+
+	var subscribed, duplicates int
+	failures := make([]string, 0)
+	last := time.Now()
+
+	var limit *LimitError
+	stopped := false
+
+	for index, feed := range feeds {
+		select {
+		case <-session.quit:
+			stopped = true
+		default:
+		}
+		if stopped {
+			break
+		}
+
+		_, err := target.ImportFeed(feed)
+
+		var duplicate *DuplicateError
+		if errors.As(err, &duplicate) {
+			duplicates++
+		} else if errors.As(err, &limit) {
+			// The feeds left would all fail the same way.
+			break
+		} else if err != nil {
+			log.Printf("Chat %d failed to import %s: %v", target.id, feed.URL, err)
+
+			title := feed.Title
+			if len(title) == 0 {
+				title = feed.URL
+			}
+			failures = append(failures, format("%s: %s", link(title, feed.URL), err.Error()))
+		} else {
+			subscribed++
+		}
+
+		if progress.MessageID != 0 && time.Since(last) >= progressInterval && index < len(feeds)-1 {
+			last = time.Now()
+
+			err := session.Edit(chatID, progress.MessageID, format("Importing feeds, %d of %d done…", index+1, len(feeds)), nil)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	summary := format("Import finished: %d subscribed, %d already subscribed, %d failed.", subscribed, duplicates, len(failures))
+	if limit != nil {
+		summary += format("\nStopped at the limit of %d subscriptions, %d feeds were left out.", limit.Limit, len(feeds)-subscribed-duplicates-len(failures))
+	}
+	if stopped {
+		summary += format("\nThe bot is restarting, %d feeds were left out, please import the file again.", len(feeds)-subscribed-duplicates-len(failures))
+	}
+	if progress.MessageID != 0 {
+		err = session.Edit(chatID, progress.MessageID, summary, nil)
+	} else {
+		err = session.Send(chatID, summary)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	if len(failures) > 0 {
+		err = session.Send(chatID, format("Failed to import:\n")+strings.Join(failures, "\n"))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// download fetches a file sent to the bot, refusing files larger than limit
+// bytes. Its errors leave out the url of the file, which holds the bot token.
+func (session *Session) download(fileID string, limit int) ([]byte, error) {
+	address, err := session.bot.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("locating file %s: %v", fileID, withoutURL(err))
+	}
+
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(address)
+	if err != nil {
+		return nil, fmt.Errorf("downloading file %s: %v", fileID, withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading file %s: %s", fileID, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading file %s: %v", fileID, withoutURL(err))
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("file %s is larger than %d bytes", fileID, limit)
+	}
+
+	return data, nil
+}
+
+// withoutURL strips the url from the errors of a request.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Telegram allows about 30 messages per second overall, one per second in a
+// private chat and 20 per minute in a group.
+const (
+	globalRate = 30
+	maxRetries = 5
+	courierTTL = time.Minute
+)
+
+type Queue struct {
+	limiter  *TokenBucket
+	couriers map[int64]*Courier
+	mutex    sync.Mutex
+}
+
+type Courier struct {
+	limiter    *TokenBucket
+	deliveries chan *Delivery
+	// pending counts the deliveries handed to the courier and not yet sent,
+	// guarded by the queue mutex.
+	pending int
+}
+
+type Delivery struct {
+	chattable tgbotapi.Chattable
+	done      chan deliveryResult
+}
+
+type deliveryResult struct {
+	message tgbotapi.Message
+	err     error
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		limiter:  NewTokenBucket(globalRate, time.Second),
+		couriers: make(map[int64]*Courier),
+	}
+}
+
+// Deliver queues the message behind earlier ones of the same chat and blocks
+// until it has been sent or has finally failed.
+func (session *Session) Deliver(chatID int64, chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	delivery := &Delivery{
+		chattable: chattable,
+		done:      make(chan deliveryResult, 1),
+	}
+
+	queue := session.queue
+
+	queue.mutex.Lock()
+	courier := queue.couriers[chatID]
+	if courier == nil {
+		courier = &Courier{
+			deliveries: make(chan *Delivery, 1000),
+		}
+		if chatID > 0 {
+			courier.limiter = NewTokenBucket(1, time.Second)
+		} else {
+			courier.limiter = NewTokenBucket(20, time.Minute)
+		}
+		queue.couriers[chatID] = courier
+		go session.courier(chatID, courier)
+	}
+	// Counted under the lock so the courier can't retire before the delivery
+	// arrives, which may wait for room without blocking other chats.
+	courier.pending++
+	queue.mutex.Unlock()
+
+	courier.deliveries <- delivery
+
+	result := <-delivery.done
+	return result.message, result.err
+}
+
+func (session *Session) courier(chatID int64, courier *Courier) {
+	queue := session.queue
+
+	for {
+		select {
+		case delivery := <-courier.deliveries:
+			message, err := session.attempt(courier, delivery.chattable)
+			delivery.done <- deliveryResult{message, err}
+
+			queue.mutex.Lock()
+			courier.pending--
+			queue.mutex.Unlock()
+
+		case <-time.After(courierTTL):
+			queue.mutex.Lock()
+			if courier.pending == 0 {
+				delete(queue.couriers, chatID)
+				queue.mutex.Unlock()
+				return
+			}
+			queue.mutex.Unlock()
+		}
+	}
+}
+
+// attempt sends the message, retrying only when Telegram asked us to slow
+// down, which guarantees the message was not delivered.
+func (session *Session) attempt(courier *Courier, chattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	queue := session.queue
+
+	var message tgbotapi.Message
+	var err error
+	for retry := 0; retry <= maxRetries; retry++ {
+		courier.limiter.Wait()
+		queue.limiter.Wait()
+
+		message, err = session.bot.Send(chattable)
+		if err == nil {
+			return message, nil
+		}
+
+		apiErr, ok := err.(tgbotapi.Error)
+		if !ok || apiErr.RetryAfter <= 0 {
+			return message, err
+		}
+
+		delay := time.Duration(apiErr.RetryAfter) * time.Second
+		courier.limiter.Pause(delay)
+		queue.limiter.Pause(delay)
+	}
+
+	return message, err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Listen starts the webhook listener and registers it with Telegram, requests
+// without the secret token are rejected.
+func (session *Session) Listen() (<-chan Update, error) {
+	link, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(webhookSecret) == 0 {
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		webhookSecret = hex.EncodeToString(secret)
+	}
+
+	path := link.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, session)
+
+	// Unbuffered, a request is only answered once the update is taken for
+	// handling, which completes before shutting down.
+	session.updates = make(chan Update)
+	session.server = &http.Server{
+		Addr:    listenAddress,
+		Handler: mux,
+	}
+
+	// The listener and the certificate are set up here so their errors stop the
+	// webhook from being registered.
+	listener, err := net.Listen("tcp", listenAddress)
+	if err != nil {
+		return nil, err
+	}
+	if len(tlsCert) > 0 && len(tlsKey) > 0 {
+		certificate, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		session.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{certificate}}
+		listener = tls.NewListener(listener, session.server.TLSConfig)
+	}
+
+	go func() {
+		err := session.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}()
+
+	_, err = session.bot.MakeRequest("setWebhook", url.Values{
+		"url":          []string{link.String()},
+		"secret_token": []string{webhookSecret},
+	})
+	if err != nil {
+		session.server.Close()
+		return nil, err
+	}
+
+	log.Printf("Webhook listening on %s", listenAddress)
+
+	return session.updates, nil
+}
+
+// Unlisten removes the webhook so Telegram holds updates until the next start,
+// then waits for pending requests to be answered.
+func (session *Session) Unlisten() {
+	_, err := session.bot.MakeRequest("deleteWebhook", url.Values{})
+	if err != nil {
+		log.Println(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = session.server.Shutdown(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (session *Session) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(webhookSecret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var update Update
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// The update is only acknowledged once it is taken for handling, Telegram
+	// redelivers the others so nothing is lost while shutting down.
+	select {
+	case session.updates <- update:
+		w.WriteHeader(http.StatusOK)
+	case <-session.quit:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
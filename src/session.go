@@ -1,7 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
@@ -10,7 +17,16 @@ import (
 type Session struct {
 	bot     *tgbotapi.BotAPI
 	token   string
-	handler func(s *Session, update tgbotapi.Update)
+	handler func(s *Session, update Update)
+	server  *http.Server
+	updates chan Update
+	queue   *Queue
+	quit    chan struct{}
+	done    chan struct{}
+	// imports holds the chats with an import running, guarded by importsMutex.
+	imports      map[int64]bool
+	importsMutex sync.Mutex
+	importing    sync.WaitGroup
 }
 
 func SharedSession() *Session {
@@ -20,16 +36,13 @@ func SharedSession() *Session {
 			log.Fatal(err)
 		}
 
-		u := tgbotapi.NewUpdate(0)
-		u.Timeout = 10
-		_, err = bot.GetUpdates(u)
-		if err != nil {
-			log.Fatal(err)
-		}
-
 		session = &Session{
-			token: token,
-			bot:   bot,
+			token:   token,
+			bot:     bot,
+			queue:   NewQueue(),
+			quit:    make(chan struct{}),
+			done:    make(chan struct{}),
+			imports: make(map[int64]bool),
 		}
 	})
 	return session
@@ -40,67 +53,231 @@ func InitSession() {
 	log.Println(`Session initialized`)
 }
 
-func (session *Session) SetHandler(handler func(s *Session, update tgbotapi.Update)) {
+func (session *Session) SetHandler(handler func(s *Session, update Update)) {
 	session.handler = handler
 }
 
 func (session *Session) Run() {
-	session.SetHandler(func(s *Session, update tgbotapi.Update) {
-		log.Println(update.Message.Text)
+	session.SetHandler(func(s *Session, update Update) {
+		if update.CallbackQuery != nil {
+			session.HandleCallback(update.CallbackQuery)
+			return
+		}
 
-		id := update.Message.Chat.ID
+		if update.MyChatMember != nil {
+			err := HandleMembership(update.MyChatMember)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+
+		// Commands issued inside channels arrive as channel posts.
+		message := &Message{Message: update.Message, SenderChat: update.SenderChat}
+		if message.Message == nil {
+			message = &Message{Message: update.ChannelPost}
+		}
+		if message.Message == nil {
+			return
+		}
+
+		if message.MigrateToChatID != 0 || message.MigrateFromChatID != 0 {
+			from, to := message.Chat.ID, message.MigrateToChatID
+			if message.MigrateFromChatID != 0 {
+				from, to = message.MigrateFromChatID, message.Chat.ID
+			}
+
+			err := MigrateContext(from, to)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
 
-		kind := 0
-		if update.Message.Chat.IsPrivate() {
-			kind = 0
-		} else if update.Message.Chat.IsGroup() || update.Message.Chat.IsSuperGroup() {
-			kind = 1
-		} else if update.Message.Chat.IsChannel() {
-			kind = 2
+		// Commands sent along with a file come as its caption.
+		if message.Document != nil && len(message.Text) == 0 && strings.HasPrefix(message.Caption, "/") {
+			command, _ := splitFirst(message.Caption)
+			message.Text = message.Caption
+			message.Entities = &[]tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
 		}
 
-		context, err := NewContext(id, kind)
+		log.Println(message.Text)
+
+		context, err := NewContext(message.Chat.ID, chatKind(message.Chat))
 		if err != nil {
 			log.Println(err)
 			return
 		}
 
-		if update.Message.IsCommand() {
-			switch update.Message.Command() {
+		// Hearing from the chat means the bot can reach it again.
+		err = context.Activate()
+		if err != nil {
+			log.Println(err)
+		}
+
+		if message.IsCommand() {
+			switch message.Command() {
 			case "start":
 				{
-					session.Send(context.id, "Greetings.")
+					session.Send(context.id, format("Greetings."))
 					break
 				}
 
 			case "list":
 				{
-					response := context.HandleListCommand()
-					session.Reply(context.id, update.Message.MessageID, response)
+					var keyboard *tgbotapi.InlineKeyboardMarkup
+					target, args, response := session.Target(context, message)
+					if target != nil {
+						response, keyboard = target.HandleListCommand(args)
+					}
+					session.ReplyWithKeyboard(message.Chat.ID, message.MessageID, response, keyboard)
 					break
 				}
 
 			case "add", "subscribe":
 				{
-					args := update.Message.CommandArguments()
-					response := context.HandleSubscribeCommand(args)
-					session.Reply(context.id, update.Message.MessageID, response)
+					var keyboard *tgbotapi.InlineKeyboardMarkup
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response, keyboard = target.HandleSubscribeCommand(args)
+					}
+					session.ReplyWithKeyboard(message.Chat.ID, message.MessageID, response, keyboard)
 					break
 				}
 
 			case "delete", "unsubscribe":
 				{
-					args := update.Message.CommandArguments()
-					response := context.HandleUnsubscribeCommand(args)
-					session.Reply(context.id, update.Message.MessageID, response)
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleUnsubscribeCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "filter", "filters":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleFilterCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "digest":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleDigestCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "timezone":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleTimezoneCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "pause", "resume":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandlePauseCommand(args, message.Command() == "pause")
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "mute":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleMuteCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "quiet":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleQuietCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "failed":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleFailedCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "template":
+				{
+					target, args, response := session.Manage(context, message)
+					if target != nil {
+						response = target.HandleTemplateCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
+					break
+				}
+
+			case "export":
+				{
+					target, _, response := session.Target(context, message)
+					if target != nil {
+						response = session.HandleExportCommand(target, message.Message)
+					}
+					if len(response) > 0 {
+						session.Reply(message.Chat.ID, message.MessageID, response)
+					}
+					break
+				}
+
+			case "import":
+				{
+					target, _, response := session.Manage(context, message)
+					if target != nil {
+						response = session.HandleImportCommand(target, message.Message)
+					}
+					if len(response) > 0 {
+						session.Reply(message.Chat.ID, message.MessageID, response)
+					}
+					break
+				}
+
+			case "permission":
+				{
+					response := session.Authorize(context, message, true)
+					if len(response) == 0 {
+						args := message.CommandArguments()
+						if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+							args = fmt.Sprintf("%s %d", args, message.ReplyToMessage.From.ID)
+						}
+						response = context.HandlePermissionCommand(args)
+					}
+					session.Reply(message.Chat.ID, message.MessageID, response)
 					break
 				}
 
 			case "hot", "top":
 				{
-					args := update.Message.CommandArguments()
+					args := message.CommandArguments()
 					response := context.HandleHotCommand(args)
-					session.Reply(context.id, update.Message.MessageID, response)
+					session.Reply(message.Chat.ID, message.MessageID, response)
 					break
 				}
 			default:
@@ -113,38 +290,200 @@ func (session *Session) Run() {
 }
 
 func (session *Session) Schedule() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 10
+	defer close(session.done)
+
+	var updates <-chan Update
+	var err error
+	if mode == "webhook" {
+		updates, err = session.Listen()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer session.Unlisten()
+	} else {
+		updates, err = session.Poll()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-session.quit:
+			return
+		case update := <-updates:
+			session.handler(session, update)
+		}
+	}
+}
+
+// Poll long polls getUpdates itself, the library drops the update kinds it
+// doesn't know such as my_chat_member.
+func (session *Session) Poll() (<-chan Update, error) {
+	// Telegram refuses getUpdates while a webhook is registered.
+	_, err := session.bot.MakeRequest("deleteWebhook", url.Values{})
+	if err != nil {
+		return nil, err
+	}
 
-	updates, err := session.bot.GetUpdatesChan(u)
+	// Skip the updates piled up while the bot was offline.
+	pending, err := session.getUpdates(-1, 0)
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
-	time.Sleep(time.Millisecond * 500)
-	updates.Clear()
+	offset := 0
+	if len(pending) > 0 {
+		offset = pending[len(pending)-1].UpdateID + 1
+	}
+
+	// Unbuffered like the webhook, the next offset only confirms the updates
+	// taken for handling.
+	updates := make(chan Update)
+
+	go func() {
+		for {
+			select {
+			case <-session.quit:
+				return
+			default:
+			}
+
+			batch, err := session.getUpdates(offset, 10)
+			if err != nil {
+				log.Println(err)
+				log.Println("Failed to get updates, retrying in 3 seconds...")
+				time.Sleep(time.Second * 3)
+				continue
+			}
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
+			for _, update := range batch {
+				if update.UpdateID < offset {
+					continue
+				}
+				offset = update.UpdateID + 1
+
+				select {
+				case updates <- update:
+				case <-session.quit:
+					return
+				}
+			}
 		}
+	}()
 
-		session.handler(session, update)
+	return updates, nil
+}
+
+func (session *Session) getUpdates(offset int, timeout int) ([]Update, error) {
+	v := url.Values{}
+	v.Add("offset", strconv.Itoa(offset))
+	v.Add("timeout", strconv.Itoa(timeout))
+
+	resp, err := session.bot.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, err
 	}
+
+	var updates []Update
+	err = json.Unmarshal(resp.Result, &updates)
+
+	return updates, err
+}
+
+// Stop stops accepting updates and waits for the update being handled and the
+// imports to wind down.
+func (session *Session) Stop() {
+	close(session.quit)
+	<-session.done
+	session.importing.Wait()
 }
 
 func (session *Session) Send(chatID int64, message string) error {
-	msg := tgbotapi.NewMessage(chatID, message)
-	msg.ParseMode = "markdown"
-	_, err := session.bot.Send(msg)
-	return err
+	return session.send(chatID, 0, SharedFormatter(), message, false, nil)
+}
+
+// SendSilently sends the message without a notification sound.
+func (session *Session) SendSilently(chatID int64, message string) error {
+	return session.send(chatID, 0, SharedFormatter(), message, true, nil)
+}
+
+// SendEntry sends an outbox entry in the parse mode it was rendered for.
+func (session *Session) SendEntry(chatID int64, entry *OutboxEntry, silent bool) error {
+	return session.send(chatID, 0, FormatterFor(entry.ParseMode), entry.Message, silent, nil)
 }
 
 func (session *Session) Reply(chatID int64, replyToMessageID int, message string) error {
-	msg := tgbotapi.NewMessage(chatID, message)
-	msg.ParseMode = "markdown"
-	msg.ReplyToMessageID = replyToMessageID
-	_, err := session.bot.Send(msg)
-	return err
+	return session.send(chatID, replyToMessageID, SharedFormatter(), message, false, nil)
+}
+
+// ReplyWithKeyboard attaches the keyboard below the reply, it may be nil.
+func (session *Session) ReplyWithKeyboard(chatID int64, replyToMessageID int, message string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	return session.send(chatID, replyToMessageID, SharedFormatter(), message, false, keyboard)
+}
+
+// send splits messages Telegram considers too long and sends a part as plain
+// text when its markup is refused. The keyboard goes below the last part.
+func (session *Session) send(chatID int64, replyToMessageID int, formatter Formatter, message string, silent bool, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	parts := Split(formatter, message, maxMessageLength)
+	for index, part := range parts {
+		msg := tgbotapi.NewMessage(chatID, part)
+		msg.ParseMode = formatter.Mode()
+		msg.DisableNotification = silent
+		if index == 0 {
+			msg.ReplyToMessageID = replyToMessageID
+		}
+		if index == len(parts)-1 && keyboard != nil {
+			msg.ReplyMarkup = *keyboard
+		}
+
+		_, err := session.Deliver(chatID, msg)
+		if isParseError(err) {
+			log.Printf("Chat %d refused the markup, sending as plain text: %v", chatID, err)
+
+			msg.Text = formatter.Plain(part)
+			msg.ParseMode = ""
+			_, err = session.Deliver(chatID, msg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isChatUnavailable reports whether Telegram refused the message because the
+// bot was blocked, removed or the chat no longer exists.
+func isChatUnavailable(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	if !ok {
+		return false
+	}
+
+	return strings.HasPrefix(apiErr.Message, "Forbidden:") || apiErr.Message == "Bad Request: chat not found"
+}
+
+// isRejected reports whether Telegram refused the message itself, sending it
+// again can't succeed. Markup it can't parse was already retried as plain text.
+func isRejected(err error) bool {
+	apiErr, ok := err.(tgbotapi.Error)
+	if !ok {
+		return false
+	}
+
+	return strings.HasPrefix(apiErr.Message, "Bad Request:")
+}
+
+// migratedChatID returns the supergroup a group was upgraded to, if that is
+// why the message was refused.
+func migratedChatID(err error) int64 {
+	apiErr, ok := err.(tgbotapi.Error)
+	if !ok {
+		return 0
+	}
+
+	return apiErr.MigrateToChatID
 }
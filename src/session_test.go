@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// TestAuthorize covers the senders decided without asking Telegram.
+func TestAuthorize(t *testing.T) {
+	session := &Session{}
+	context := newTestContext(t, -1008)
+
+	group := &tgbotapi.Chat{ID: -1008, Type: "supergroup"}
+	channel := &tgbotapi.Chat{ID: -1009, Type: "channel"}
+	anonymous := &tgbotapi.User{ID: 1087968824, UserName: "GroupAnonymousBot"}
+
+	tests := []struct {
+		name    string
+		message *Message
+		allowed bool
+	}{
+		{
+			name:    "private chat",
+			message: &Message{Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1, Type: "private"}, From: &tgbotapi.User{ID: 1}}},
+			allowed: true,
+		},
+		{
+			name:    "anonymous administrator",
+			message: &Message{Message: &tgbotapi.Message{Chat: group, From: anonymous}, SenderChat: group},
+			allowed: true,
+		},
+		{
+			name:    "on behalf of a channel",
+			message: &Message{Message: &tgbotapi.Message{Chat: group, From: &tgbotapi.User{ID: 136817688}}, SenderChat: channel},
+			allowed: false,
+		},
+		{
+			name:    "no sender",
+			message: &Message{Message: &tgbotapi.Message{Chat: group}},
+			allowed: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response := session.Authorize(context, test.message, false)
+			if allowed := len(response) == 0; allowed != test.allowed {
+				t.Errorf("allowed %v, want %v: %s", allowed, test.allowed, response)
+			}
+		})
+	}
+}
+
+func TestUpdateSenderChat(t *testing.T) {
+	data := `{"update_id":1,"message":{"message_id":2,"date":0,"chat":{"id":-1008,"type":"supergroup"},"from":{"id":1087968824,"username":"GroupAnonymousBot"},"sender_chat":{"id":-1008,"type":"supergroup"},"text":"/add"}}`
+
+	var update Update
+	if err := json.Unmarshal([]byte(data), &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Message == nil || update.Message.Text != "/add" {
+		t.Fatal("the message is missing")
+	}
+	if update.SenderChat == nil || update.SenderChat.ID != -1008 {
+		t.Errorf("sender chat %v", update.SenderChat)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+func (lite SQLite) GetAccounts() ([]*Account, error) {
+	accounts := make([]*Account, 0)
+
+	rows, err := lite.db.Query(`SELECT data FROM accounts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		var account Account
+		err = json.Unmarshal([]byte(data), &account)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, &account)
+	}
+
+	return accounts, rows.Err()
+}
+
+func (lite SQLite) GetAccount(id int64) (*Account, error) {
+	var data string
+	err := lite.db.QueryRow(`SELECT data FROM accounts WHERE id = ?`, id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var account *Account
+	err = json.Unmarshal([]byte(data), &account)
+
+	return account, err
+}
+
+func (lite SQLite) SaveAccount(account *Account) error {
+	data, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+
+	_, err = lite.db.Exec(`INSERT OR REPLACE INTO accounts (id, data) VALUES (?, ?)`, account.Id, string(data))
+
+	return err
+}
+
+func (lite SQLite) DeleteAccount(account *Account) error {
+	_, err := lite.db.Exec(`DELETE FROM accounts WHERE id = ?`, account.Id)
+
+	return err
+}
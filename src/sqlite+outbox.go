@@ -0,0 +1,57 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+func (lite SQLite) GetOutbox(account *Account) ([]*OutboxEntry, error) {
+	entries := make([]*OutboxEntry, 0)
+
+	rows, err := lite.db.Query(`SELECT data FROM outbox WHERE account_id = ? ORDER BY sequence`, account.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		var entry OutboxEntry
+		err = json.Unmarshal([]byte(data), &entry)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (lite SQLite) AddOutboxEntries(account *Account, entries []*OutboxEntry) error {
+	return lite.transaction(func(tx *sql.Tx) error {
+		for _, entry := range entries {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`INSERT OR REPLACE INTO outbox (account_id, id, sequence, data) VALUES (?, ?, ?, ?)`, account.Id, entry.Id, entry.Sequence, string(data))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (lite SQLite) DeleteOutboxEntry(account *Account, entry *OutboxEntry) error {
+	_, err := lite.db.Exec(`DELETE FROM outbox WHERE account_id = ? AND id = ?`, account.Id, entry.Id)
+
+	return err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+func (lite SQLite) GetTopSubscriptions(num int) ([]*SubscriptionStatistic, error) {
+	statistics := make([]*SubscriptionStatistic, 0)
+
+	rows, err := lite.db.Query(`SELECT data FROM statistics ORDER BY count DESC LIMIT ?`, num)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, err
+		}
+
+		var statistic SubscriptionStatistic
+		err = json.Unmarshal([]byte(data), &statistic)
+		if err != nil {
+			return nil, err
+		}
+
+		statistics = append(statistics, &statistic)
+	}
+
+	return statistics, rows.Err()
+}
+
+func (lite SQLite) GetStatistics() ([]*SubscriptionStatistic, error) {
+	return lite.GetTopSubscriptions(-1)
+}
+
+func (lite SQLite) SetStatistic(statistic *SubscriptionStatistic) error {
+	return lite.transaction(func(tx *sql.Tx) error {
+		return lite.setStatistic(tx, statistic)
+	})
+}
+
+func (lite SQLite) getStatistic(tx *sql.Tx, subscription *Subscription) (*SubscriptionStatistic, error) {
+	var data string
+	err := tx.QueryRow(`SELECT data FROM statistics WHERE subscription_id = ?`, subscription.Id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return &SubscriptionStatistic{
+			Count:        0,
+			Subscription: subscription,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var statistic SubscriptionStatistic
+	err = json.Unmarshal([]byte(data), &statistic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statistic, nil
+}
+
+func (lite SQLite) setStatistic(tx *sql.Tx, statistic *SubscriptionStatistic) error {
+	data, err := json.Marshal(statistic)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO statistics (subscription_id, count, data) VALUES (?, ?, ?)`, statistic.Subscription.Id, statistic.Count, string(data))
+
+	return err
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+func (lite SQLite) GetSubscriptions(account *Account) (map[string]*Subscription, error) {
+	subscriptions := make(map[string]*Subscription)
+
+	rows, err := lite.db.Query(`SELECT id, data FROM subscriptions WHERE account_id = ?`, account.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, data string
+		err = rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		var subscription Subscription
+		err = json.Unmarshal([]byte(data), &subscription)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions[id] = &subscription
+	}
+
+	return subscriptions, rows.Err()
+}
+
+func (lite SQLite) AddSubscription(account *Account, subscription *Subscription) error {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	return lite.transaction(func(tx *sql.Tx) error {
+		statistic, err := lite.getStatistic(tx, subscription)
+		if err != nil {
+			return err
+		}
+
+		statistic.Count++
+
+		err = lite.setStatistic(tx, statistic)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT OR REPLACE INTO subscriptions (account_id, id, data) VALUES (?, ?, ?)`, account.Id, subscription.Id, string(data))
+		return err
+	})
+}
+
+func (lite SQLite) SaveSubscription(account *Account, subscription *Subscription) error {
+	data, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	_, err = lite.db.Exec(`INSERT OR REPLACE INTO subscriptions (account_id, id, data) VALUES (?, ?, ?)`, account.Id, subscription.Id, string(data))
+
+	return err
+}
+
+func (lite SQLite) DeleteSubscription(account *Account, subscription *Subscription) error {
+	return lite.transaction(func(tx *sql.Tx) error {
+		statistic, err := lite.getStatistic(tx, subscription)
+		if err != nil {
+			return err
+		}
+
+		statistic.Count--
+
+		if statistic.Count <= 0 {
+			_, err = tx.Exec(`DELETE FROM statistics WHERE subscription_id = ?`, subscription.Id)
+		} else {
+			err = lite.setStatistic(tx, statistic)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`DELETE FROM subscriptions WHERE account_id = ? AND id = ?`, account.Id, subscription.Id)
+		return err
+	})
+}
+
+func (lite SQLite) GetFeedCache(account *Account, subscription *Subscription) (map[string]interface{}, error) {
+	cache := make(map[string]interface{})
+
+	var data string
+	err := lite.db.QueryRow(`SELECT data FROM caches WHERE account_id = ? AND subscription_id = ?`, account.Id, subscription.Id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal([]byte(data), &cache)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache, nil
+}
+
+func (lite SQLite) SetFeedCache(account *Account, subscription *Subscription, cache map[string]interface{}) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	_, err = lite.db.Exec(`INSERT OR REPLACE INTO caches (account_id, subscription_id, data) VALUES (?, ?, ?)`, account.Id, subscription.Id, string(data))
+
+	return err
+}
+
+func (lite SQLite) DeleteFeedCache(account *Account, subscription *Subscription) error {
+	_, err := lite.db.Exec(`DELETE FROM caches WHERE account_id = ? AND subscription_id = ?`, account.Id, subscription.Id)
+
+	return err
+}
+
+func (lite SQLite) transaction(fn func(tx *sql.Tx) error) error {
+	tx, err := lite.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type SQLite struct {
+	db *sql.DB
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS accounts (
+	id   INTEGER PRIMARY KEY,
+	data TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	account_id INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (account_id, id)
+);
+
+CREATE TABLE IF NOT EXISTS caches (
+	account_id      INTEGER NOT NULL,
+	subscription_id TEXT NOT NULL,
+	data            TEXT NOT NULL,
+	PRIMARY KEY (account_id, subscription_id)
+);
+
+CREATE TABLE IF NOT EXISTS statistics (
+	subscription_id TEXT PRIMARY KEY,
+	count           INTEGER NOT NULL,
+	data            TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS statistics_count ON statistics (count);
+
+CREATE TABLE IF NOT EXISTS outbox (
+	account_id INTEGER NOT NULL,
+	id         TEXT NOT NULL,
+	sequence   INTEGER NOT NULL,
+	data       TEXT NOT NULL,
+	PRIMARY KEY (account_id, id)
+);
+`
+
+func SharedSQLite() SQLite {
+	sqliteOnce.Do(func() {
+		if dir := filepath.Dir(database); dir != "" {
+			err := os.MkdirAll(dir, 0755)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		db, err := sql.Open("sqlite3", "file:"+database+"?_busy_timeout=5000&_journal_mode=WAL")
+		if err != nil {
+			panic(err)
+		}
+
+		// SQLite allows a single writer at a time, serialize access through one connection.
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec(schema)
+		if err != nil {
+			panic(err)
+		}
+
+		lite = SQLite{
+			db: db,
+		}
+	})
+	return lite
+}
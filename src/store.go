@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+)
+
+type Store interface {
+	GetAccounts() ([]*Account, error)
+	GetAccount(id int64) (*Account, error)
+	SaveAccount(account *Account) error
+	DeleteAccount(account *Account) error
+
+	GetSubscriptions(account *Account) (map[string]*Subscription, error)
+	AddSubscription(account *Account, subscription *Subscription) error
+	SaveSubscription(account *Account, subscription *Subscription) error
+	DeleteSubscription(account *Account, subscription *Subscription) error
+
+	GetFeedCache(account *Account, subscription *Subscription) (map[string]interface{}, error)
+	SetFeedCache(account *Account, subscription *Subscription, cache map[string]interface{}) error
+	DeleteFeedCache(account *Account, subscription *Subscription) error
+
+	GetOutbox(account *Account) ([]*OutboxEntry, error)
+	AddOutboxEntries(account *Account, entries []*OutboxEntry) error
+	DeleteOutboxEntry(account *Account, entry *OutboxEntry) error
+
+	GetTopSubscriptions(num int) ([]*SubscriptionStatistic, error)
+	GetStatistics() ([]*SubscriptionStatistic, error)
+	SetStatistic(statistic *SubscriptionStatistic) error
+}
+
+func SharedStore() Store {
+	storeOnce.Do(func() {
+		store = NewStore(storage)
+	})
+	return store
+}
+
+func NewStore(kind string) Store {
+	switch kind {
+	case "firebase":
+		return SharedFirebase()
+	case "sqlite":
+		return SharedSQLite()
+	default:
+		log.Fatalf("unknown storage %s", kind)
+		return nil
+	}
+}
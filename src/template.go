@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+const (
+	defaultTemplate = "title"
+	summaryLength   = 300
+)
+
+// Built-in templates, picked by name instead of spelling the template out.
+var templatePresets = map[string]string{
+	"title":   `{{link .Title .Link}}`,
+	"summary": "{{bold .Title}}\n{{.Summary}}\n\n{{link \"Read more\" .Link}}",
+	"source":  "{{link .Title .Link}}\n{{italic .Source.Title}}",
+	"compact": `{{.Source.Title}}: {{link .Title .Link}}`,
+}
+
+var templatePresetNames = []string{"title", "summary", "source", "compact"}
+
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// TemplateData is what templates see of an item, text is already escaped for
+// the parse mode messages are sent with. Description and Content keep the
+// formatting of the feed Telegram supports.
+type TemplateData struct {
+	Title       string
+	Link        string
+	Summary     string
+	Description string
+	Content     string
+	Authors     []string
+	Categories  []string
+	Published   time.Time
+	Updated     time.Time
+	Image       string
+	Comments    string
+	Enclosures  []string
+	Source      TemplateSource
+}
+
+type TemplateSource struct {
+	Title string
+	Link  string
+}
+
+// The markup functions take the escaped fields, the literal text of templates is
+// escaped when they are parsed.
+var templateFuncs = template.FuncMap{
+	"join": strings.Join,
+	"link": func(text string, url string) string {
+		return SharedFormatter().Link(text, url)
+	},
+	"bold": func(text string) string {
+		return SharedFormatter().Bold(text)
+	},
+	"italic": func(text string) string {
+		return SharedFormatter().Italic(text)
+	},
+	"code": func(text string) string {
+		return SharedFormatter().Code(text)
+	},
+}
+
+// NewTemplate parses a preset name or a template text.
+func NewTemplate(text string) (*template.Template, error) {
+	if preset, ok := templatePresets[text]; ok {
+		text = preset
+	}
+
+	tmpl, err := template.New("message").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	escape := SharedFormatter().Escape
+	for _, defined := range tmpl.Templates() {
+		if defined.Tree != nil {
+			escapeLiterals(defined.Tree.Root, escape)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// escapeLiterals escapes the text and the string constants of a template the
+// way the fields are, so they compare and render alike.
+func escapeLiterals(node parse.Node, escape func(string) string) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+		for _, child := range node.Nodes {
+			escapeLiterals(child, escape)
+		}
+	case *parse.TextNode:
+		node.Text = []byte(escape(string(node.Text)))
+	case *parse.StringNode:
+		node.Text = escape(node.Text)
+	case *parse.ActionNode:
+		escapeLiterals(node.Pipe, escape)
+	case *parse.TemplateNode:
+		escapeLiterals(node.Pipe, escape)
+	case *parse.PipeNode:
+		if node == nil {
+			return
+		}
+		for _, command := range node.Cmds {
+			escapeLiterals(command, escape)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			escapeLiterals(arg, escape)
+		}
+	case *parse.ChainNode:
+		escapeLiterals(node.Node, escape)
+	case *parse.IfNode:
+		escapeLiterals(&node.BranchNode, escape)
+	case *parse.RangeNode:
+		escapeLiterals(&node.BranchNode, escape)
+	case *parse.WithNode:
+		escapeLiterals(&node.BranchNode, escape)
+	case *parse.BranchNode:
+		escapeLiterals(node.Pipe, escape)
+		escapeLiterals(node.List, escape)
+		escapeLiterals(node.ElseList, escape)
+	}
+}
+
+// RenderItem renders the item of the subscription with the template, times are
+// given in the location of the chat.
+func RenderItem(text string, subscription *Subscription, item *Item, location *time.Location) (string, error) {
+	tmpl, err := NewTemplate(text)
+	if err != nil {
+		return "", err
+	}
+
+	escape := SharedFormatter().Escape
+
+	data := &TemplateData{
+		Title:       escape(item.title),
+		Link:        escape(item.link),
+		Summary:     escape(summarize(item.description, item.content)),
+		Description: Sanitize(item.description),
+		Content:     Sanitize(item.content),
+		Authors:     escapeAll(item.authors),
+		Categories:  escapeAll(item.categories),
+		Published:   item.published.In(location),
+		Updated:     item.updated.In(location),
+		Image:       escape(item.image),
+		Comments:    escape(item.comments),
+		Source: TemplateSource{
+			Title: escape(subscription.Title),
+			Link:  escape(subscription.Link),
+		},
+	}
+	for _, enclosure := range item.enclosures {
+		data.Enclosures = append(data.Enclosures, escape(enclosure.url))
+	}
+
+	var buffer bytes.Buffer
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	message := strings.TrimSpace(buffer.String())
+	if len(message) == 0 {
+		return "", fmt.Errorf("template renders an empty message")
+	}
+
+	return message, nil
+}
+
+// ValidateTemplate renders a sample item to catch errors before the template is saved.
+func ValidateTemplate(text string) (string, error) {
+	subscription := &Subscription{
+		Title: "Example Feed",
+		Link:  "https://example.com/feed.xml",
+	}
+
+	return RenderItem(text, subscription, sampleItem(), time.UTC)
+}
+
+func sampleItem() *Item {
+	return &Item{
+		title:       "Example item",
+		link:        "https://example.com/posts/1",
+		description: "<p>The first paragraph of the example item.</p>",
+		content:     "<p>The first paragraph of the example item.</p><p>And the rest of it.</p>",
+		published:   time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC),
+		updated:     time.Date(2021, 5, 1, 8, 0, 0, 0, time.UTC),
+		authors:     []string{"Jane Doe"},
+		categories:  []string{"news"},
+		comments:    "https://example.com/posts/1#comments",
+	}
+}
+
+// summarize returns the start of the description as plain text, the content
+// stands in for feeds without one.
+func summarize(description string, content string) string {
+	text := plainText(description)
+	if len(text) == 0 {
+		text = plainText(content)
+	}
+
+	runes := []rune(text)
+	if len(runes) > summaryLength {
+		text = strings.TrimSpace(string(runes[:summaryLength])) + "…"
+	}
+
+	return text
+}
+
+func plainText(text string) string {
+	text = tagPattern.ReplaceAllString(text, " ")
+	return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+}
+
+func escapeAll(texts []string) []string {
+	escaped := make([]string, 0, len(texts))
+	for _, text := range texts {
+		escaped = append(escaped, SharedFormatter().Escape(text))
+	}
+	return escaped
+}
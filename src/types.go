@@ -1,15 +1,92 @@
 package main
 
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
 type Account struct {
-	Id   int64 `firestore:"id"`
-	Kind int   `firestore:"kind"`
+	Id         int64   `firestore:"id" json:"id"`
+	Kind       int     `firestore:"kind" json:"kind"`
+	Inactive   bool    `firestore:"inactive" json:"inactive"`
+	Permission int     `firestore:"permission" json:"permission"`
+	Allowlist  []int64 `firestore:"allowlist" json:"allowlist"`
+	Timezone   string  `firestore:"timezone" json:"timezone"`
+	Digest     Digest  `firestore:"digest" json:"digest"`
+	Paused     bool    `firestore:"paused" json:"paused"`
+	MutedUntil int64   `firestore:"muted_until" json:"muted_until"`
+	Quiet      Quiet   `firestore:"quiet" json:"quiet"`
+	Template   string  `firestore:"template" json:"template"`
+}
+
+// Quiet hours run from Start to End in the chat's timezone, messages are then
+// held until End or sent without notification.
+type Quiet struct {
+	Start string `firestore:"start" json:"start"`
+	End   string `firestore:"end" json:"end"`
+	Hold  bool   `firestore:"hold" json:"hold"`
 }
 
+// Digest batches the new items of a chat into one message sent at the given
+// times of day, or every Interval minutes, in the chat's timezone.
+type Digest struct {
+	Enabled  bool     `firestore:"enabled" json:"enabled"`
+	Times    []string `firestore:"times" json:"times"`
+	Interval int      `firestore:"interval" json:"interval"`
+	Limit    int      `firestore:"limit" json:"limit"`
+}
+
+// Who may manage the subscriptions of a group, administrators can always.
+// Everyone is the zero value so accounts stored before permissions existed
+// keep working as they did.
+const (
+	PermissionEveryone = iota
+	PermissionAdmins
+	PermissionAllowlist
+)
+
 type Subscription struct {
-	Id        string `firestore:"id"`
-	Link      string `firestore:"link"`
-	Title     string `firestore:"title"`
-	Timestamp int64  `firestore:"timestamp"`
+	Id         string    `firestore:"id" json:"id"`
+	Link       string    `firestore:"link" json:"link"`
+	Title      string    `firestore:"title" json:"title"`
+	Timestamp  int64     `firestore:"timestamp" json:"timestamp"`
+	Filters    []*Filter `firestore:"filters" json:"filters"`
+	Delivery   string    `firestore:"delivery" json:"delivery"`
+	Paused     bool      `firestore:"paused" json:"paused"`
+	MutedUntil int64     `firestore:"muted_until" json:"muted_until"`
+	Template   string    `firestore:"template" json:"template"`
+	Tags       []string  `firestore:"tags" json:"tags"`
+}
+
+// How the items of a subscription are delivered, the default follows the chat.
+const (
+	DeliveryDefault = ""
+	DeliveryInstant = "instant"
+	DeliveryDigest  = "digest"
+)
+
+type Filter struct {
+	Exclude       bool     `firestore:"exclude" json:"exclude"`
+	Pattern       string   `firestore:"pattern" json:"pattern"`
+	Regex         bool     `firestore:"regex" json:"regex"`
+	CaseSensitive bool     `firestore:"case_sensitive" json:"case_sensitive"`
+	Fields        []string `firestore:"fields" json:"fields"`
+	compiled      *regexp.Regexp
+}
+
+type OutboxEntry struct {
+	Id             string `firestore:"id" json:"id"`
+	SubscriptionId string `firestore:"subscription_id" json:"subscription_id"`
+	ItemId         string `firestore:"item_id" json:"item_id"`
+	Message        string `firestore:"message" json:"message"`
+	Sequence       int64  `firestore:"sequence" json:"sequence"`
+	Digest         bool   `firestore:"digest" json:"digest"`
+	ParseMode      string `firestore:"parse_mode" json:"parse_mode"`
+	Failed         bool   `firestore:"failed" json:"failed"`
+	attempts       int
 }
 
 type Channel struct {
@@ -17,15 +94,82 @@ type Channel struct {
 	title       string
 	description string
 	link        string
+	site        string
+	image       string
+	language    string
+	updated     time.Time
+	authors     []string
+	categories  []string
 }
 
 type Item struct {
-	id    string
-	title string
-	link  string
+	id          string
+	title       string
+	link        string
+	description string
+	content     string
+	published   time.Time
+	updated     time.Time
+	authors     []string
+	categories  []string
+	enclosures  []*Enclosure
+	image       string
+	comments    string
+}
+
+type Enclosure struct {
+	url    string
+	kind   string
+	length int64
 }
 
 type SubscriptionStatistic struct {
-	Count        int64         `firestore:"count"`
-	Subscription *Subscription `firestore:"subscription"`
+	Count        int64         `firestore:"count" json:"count"`
+	Subscription *Subscription `firestore:"subscription" json:"subscription"`
+}
+
+// Update extends the library update with the kinds and fields it doesn't decode.
+type Update struct {
+	tgbotapi.Update
+	MyChatMember *ChatMemberUpdated `json:"my_chat_member"`
+	// SenderChat is the chat the message was sent on behalf of, the group
+	// itself for its anonymous administrators.
+	SenderChat *tgbotapi.Chat `json:"-"`
+}
+
+func (update *Update) UnmarshalJSON(data []byte) error {
+	type fields Update
+	err := json.Unmarshal(data, (*fields)(update))
+	if err != nil {
+		return err
+	}
+
+	var sender struct {
+		Message *struct {
+			SenderChat *tgbotapi.Chat `json:"sender_chat"`
+		} `json:"message"`
+	}
+	err = json.Unmarshal(data, &sender)
+	if err != nil {
+		return err
+	}
+	if sender.Message != nil {
+		update.SenderChat = sender.Message.SenderChat
+	}
+
+	return nil
+}
+
+// Message is a message along with the chat it was sent on behalf of.
+type Message struct {
+	*tgbotapi.Message
+	SenderChat *tgbotapi.Chat
+}
+
+type ChatMemberUpdated struct {
+	Chat          tgbotapi.Chat       `json:"chat"`
+	From          tgbotapi.User       `json:"from"`
+	Date          int                 `json:"date"`
+	OldChatMember tgbotapi.ChatMember `json:"old_chat_member"`
+	NewChatMember tgbotapi.ChatMember `json:"new_chat_member"`
 }
@@ -3,6 +3,9 @@ package main
 import (
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func isValidURL(text string) bool {
@@ -26,3 +29,45 @@ func FileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+func containsID(ids []int64, id int64) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDuration accepts days on top of the units of time.ParseDuration, such as 2d.
+func parseDuration(text string) (time.Duration, error) {
+	if strings.HasSuffix(text, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(text, "d"))
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	return time.ParseDuration(text)
+}
+
+// splitFirst splits off the first word, the rest keeps its line breaks.
+func splitFirst(text string) (string, string) {
+	text = strings.TrimSpace(text)
+
+	index := strings.IndexAny(text, " \t\n")
+	if index < 0 {
+		return text, ""
+	}
+
+	return text[:index], strings.TrimSpace(text[index:])
+}
@@ -1,18 +1,49 @@
 package main
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 var (
 	token string
 
+	mode          string
+	webhookURL    string
+	webhookSecret string
+	listenAddress string
+	tlsCert       string
+	tlsKey        string
+
+	parseMode string
+
+	storage  string
+	database string
+
+	workers      int
+	fetchTimeout time.Duration
+	minInterval  time.Duration
+	maxInterval  time.Duration
+
+	failureThreshold int
+	shutdownTimeout  time.Duration
+	maxSubscriptions int
+
 	sessionOnce sync.Once
 	session     *Session
 
+	storeOnce sync.Once
+	store     Store
+
 	firebaseOnce sync.Once
 	fb           Firebase
 
+	sqliteOnce sync.Once
+	lite       SQLite
+
 	monitorOnce sync.Once
 	monitor     *Monitor
 
-	contexts map[int64]*Context = make(map[int64]*Context)
+	contextsMutex sync.Mutex
+	contexts      map[int64]*Context = make(map[int64]*Context)
 )